@@ -1,34 +1,99 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/common"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/handlers"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/models"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
 )
 
-// Listen starts a new HTTP Listener
+const (
+	// ListenerPortEnvVarName is the environment variable that contains the port the listener listens on
+	ListenerPortEnvVarName = "LISTENER_PORT"
+	// ListenerReadTimeoutEnvVarName is the environment variable that contains the maximum duration for reading a request
+	ListenerReadTimeoutEnvVarName = "LISTENER_READ_TIMEOUT"
+	// ListenerWriteTimeoutEnvVarName is the environment variable that contains the maximum duration before timing out writing a response
+	ListenerWriteTimeoutEnvVarName = "LISTENER_WRITE_TIMEOUT"
+	// ListenerIdleTimeoutEnvVarName is the environment variable that contains the maximum time to wait for the next request on a keep-alive connection
+	ListenerIdleTimeoutEnvVarName = "LISTENER_IDLE_TIMEOUT"
+	// ListenerRequestTimeoutEnvVarName is the environment variable that contains the maximum time a request is allowed to be processed for
+	ListenerRequestTimeoutEnvVarName = "LISTENER_REQUEST_TIMEOUT"
+	// ListenerShutdownTimeoutEnvVarName is the environment variable that contains the maximum time to wait for requests to complete when shutting down
+	ListenerShutdownTimeoutEnvVarName = "LISTENER_SHUTDOWN_TIMEOUT"
+	// ListenerTLSCertFileEnvVarName is the environment variable that contains the path to the TLS certificate file
+	ListenerTLSCertFileEnvVarName = "LISTENER_TLS_CERT_FILE"
+	// ListenerTLSKeyFileEnvVarName is the environment variable that contains the path to the TLS key file
+	ListenerTLSKeyFileEnvVarName = "LISTENER_TLS_KEY_FILE"
+)
 
-func Listen() {
+// ListenerOptions configures the HTTP listener, TLS is used if TLSCertFile and TLSKeyFile are set
+type ListenerOptions struct {
+	Port              string
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	RequestTimeout    time.Duration
+	ShutdownTimeout   time.Duration
+	TLSCertFile       string
+	TLSKeyFile        string
+	CanaryRegistry    string
+	MetricsRegistries []string
+}
 
-	port, exists := os.LookupEnv("LISTENER_PORT")
-	if !exists {
-		port = "8080"
+// Validate checks that the timeouts are not negative, that the request timeout does not exceed the write timeout and that the TLS certificate and key files are either both set or both not set
+func (options ListenerOptions) Validate() error {
+	for name, timeout := range map[string]time.Duration{
+		"read":     options.ReadTimeout,
+		"write":    options.WriteTimeout,
+		"idle":     options.IdleTimeout,
+		"request":  options.RequestTimeout,
+		"shutdown": options.ShutdownTimeout,
+	} {
+		if timeout < 0 {
+			return fmt.Errorf("Invalid %s timeout %s, the timeout must not be negative", name, timeout)
+		}
+	}
+	// The response to a request that times out cannot be written once the write timeout has expired
+	if options.WriteTimeout > 0 && options.RequestTimeout > options.WriteTimeout {
+		return fmt.Errorf("Invalid request timeout %s, the request timeout must not exceed the write timeout %s", options.RequestTimeout, options.WriteTimeout)
+	}
+	if (len(options.TLSCertFile) == 0) != (len(options.TLSKeyFile) == 0) {
+		return errors.New("Both a TLS certificate file and a TLS key file must be specified to use TLS")
 	}
+	return nil
+}
+
+// Listen starts a new HTTP Listener, the listener stops accepting requests and waits for requests in progress to complete when it receives SIGINT or SIGTERM
+
+func Listen(options ListenerOptions) {
+
+	// Bundle pull metrics are recorded by registry for the configured registries and the canary registry
+	common.SetMetricsRegistries(append(options.MetricsRegistries, options.CanaryRegistry))
+
 	router := chi.NewRouter()
 	router.Use(middleware.RequestID)
 	router.Use(middleware.RealIP)
+	router.Use(common.RequestLogger)
 	router.Use(common.SetOriginalRequestURI)
-	router.Use(middleware.Logger)
-	router.Use(middleware.Timeout(60 * time.Second))
+	router.Use(common.Metrics)
+	if options.RequestTimeout > 0 {
+		router.Use(middleware.Timeout(options.RequestTimeout))
+	}
 	router.Use(middleware.Recoverer)
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
@@ -49,9 +114,91 @@ func Listen() {
 	router.Handle(models.SolutionTemplatePath+"/*", handlers.NewSolutionTemplateHandler())
 	router.Handle(models.ManagedAppDefinitionPath+"/*", handlers.NewManagedAppDefinitionHandler())
 	router.Handle(models.ArcTemplatePath+"/*", handlers.NewArcHandler())
-	log.Infof("Starting to listen on port  %s", port)
-	err := http.ListenAndServe(fmt.Sprintf(":%s", port), router)
-	if err != nil {
+	router.Handle(models.MetricsPath, promhttp.Handler())
+
+	// The probes are served without the request middleware so that probe requests are not logged or counted as requests
+	probes := chi.NewRouter()
+	probes.Mount(models.HealthPath, handlers.NewHealthHandler())
+	probes.Mount(models.ReadinessPath, handlers.NewReadinessHandler(options.CanaryRegistry))
+	probes.Mount("/", router)
+
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%s", options.Port),
+		Handler:      probes,
+		ReadTimeout:  options.ReadTimeout,
+		WriteTimeout: options.WriteTimeout,
+		IdleTimeout:  options.IdleTimeout,
+	}
+
+	if len(options.TLSCertFile) > 0 {
+		reloader, err := common.NewCertificateReloader(options.TLSCertFile, options.TLSKeyFile, log.WithField("tls_cert_file", options.TLSCertFile))
+		if err != nil {
+			log.Fatalf("Error loading TLS certificate %v", err)
+		}
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+	}
+
+	shutdownComplete := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		log.Infof("Received signal %v, shutting down HTTP Server", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), options.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Infof("Error shutting down HTTP Server %v", err)
+		}
+		close(shutdownComplete)
+	}()
+
+	var err error
+	if server.TLSConfig != nil {
+		log.Infof("Starting to listen on port  %s using TLS", options.Port)
+		// The certificate is provided by the TLS config so that it can be reloaded
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Infof("Starting to listen on port  %s", options.Port)
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Error running HTTP Server %v", err)
 	}
+
+	<-shutdownComplete
+	log.Info("HTTP Server stopped")
+}
+
+// listenerFlagEnvVars maps the listen command flags to the environment variables that set them
+var listenerFlagEnvVars = map[string]string{
+	"port":               ListenerPortEnvVarName,
+	"read-timeout":       ListenerReadTimeoutEnvVarName,
+	"write-timeout":      ListenerWriteTimeoutEnvVarName,
+	"idle-timeout":       ListenerIdleTimeoutEnvVarName,
+	"request-timeout":    ListenerRequestTimeoutEnvVarName,
+	"shutdown-timeout":   ListenerShutdownTimeoutEnvVarName,
+	"tls-cert-file":      ListenerTLSCertFileEnvVarName,
+	"tls-key-file":       ListenerTLSKeyFileEnvVarName,
+	"canary-registry":    common.CanaryRegistryEnvVarName,
+	"metrics-registries": common.MetricsRegistriesEnvVarName,
+}
+
+// setListenerFlagsFromEnv sets the listen command flags that were not specified from their environment variables
+func setListenerFlagsFromEnv(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	for flagName, envVarName := range listenerFlagEnvVars {
+		if flags.Changed(flagName) {
+			continue
+		}
+		if value, exists := os.LookupEnv(envVarName); exists {
+			if err := flags.Set(flagName, value); err != nil {
+				return fmt.Errorf("Invalid value %s for environment variable %s: %w", value, envVarName, err)
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/common"
+	"github.com/spf13/cobra"
+	"gotest.tools/assert"
+)
+
+// unsetListenerEnv unsets the listener environment variables and returns a function that restores them
+func unsetListenerEnv(t *testing.T) func() {
+	values := make(map[string]string)
+	for _, envVarName := range listenerFlagEnvVars {
+		if value, exists := os.LookupEnv(envVarName); exists {
+			values[envVarName] = value
+		}
+		assert.NilError(t, os.Unsetenv(envVarName))
+	}
+	return func() {
+		for _, envVarName := range listenerFlagEnvVars {
+			if value, exists := values[envVarName]; exists {
+				os.Setenv(envVarName, value)
+			} else {
+				os.Unsetenv(envVarName)
+			}
+		}
+	}
+}
+
+func TestListenerOptionsValidate(t *testing.T) {
+	valid := ListenerOptions{
+		ReadTimeout:     30 * time.Second,
+		WriteTimeout:    90 * time.Second,
+		IdleTimeout:     120 * time.Second,
+		RequestTimeout:  60 * time.Second,
+		ShutdownTimeout: 30 * time.Second,
+	}
+	assert.NilError(t, valid.Validate())
+
+	tests := []struct {
+		name     string
+		update   func(options *ListenerOptions)
+		expected string
+	}{
+		{"negative read timeout", func(options *ListenerOptions) { options.ReadTimeout = -time.Second }, "Invalid read timeout -1s"},
+		{"negative shutdown timeout", func(options *ListenerOptions) { options.ShutdownTimeout = -time.Second }, "Invalid shutdown timeout -1s"},
+		{"negative request timeout", func(options *ListenerOptions) { options.RequestTimeout = -time.Second }, "Invalid request timeout -1s"},
+		{"request timeout exceeds write timeout", func(options *ListenerOptions) { options.RequestTimeout = 2 * time.Minute }, "must not exceed the write timeout 1m30s"},
+		{"certificate without key", func(options *ListenerOptions) { options.TLSCertFile = "tls.crt" }, "Both a TLS certificate file and a TLS key file must be specified"},
+		{"key without certificate", func(options *ListenerOptions) { options.TLSKeyFile = "tls.key" }, "Both a TLS certificate file and a TLS key file must be specified"},
+	}
+
+	for _, test := range tests {
+		options := valid
+		test.update(&options)
+		assert.ErrorContains(t, options.Validate(), test.expected, test.name)
+	}
+
+	noTimeouts := ListenerOptions{TLSCertFile: "tls.crt", TLSKeyFile: "tls.key"}
+	assert.NilError(t, noTimeouts.Validate())
+
+	noWriteTimeout := valid
+	noWriteTimeout.WriteTimeout = 0
+	noWriteTimeout.RequestTimeout = 10 * time.Minute
+	assert.NilError(t, noWriteTimeout.Validate())
+}
+
+// newTestListenCommand creates a command with a subset of the listen command flags that set options
+func newTestListenCommand(options *ListenerOptions) *cobra.Command {
+	cmd := &cobra.Command{Use: "listen"}
+	cmd.Flags().StringVar(&options.Port, "port", "8080", "")
+	cmd.Flags().DurationVar(&options.ReadTimeout, "read-timeout", 30*time.Second, "")
+	cmd.Flags().DurationVar(&options.RequestTimeout, "request-timeout", 60*time.Second, "")
+	cmd.Flags().StringSliceVar(&options.MetricsRegistries, "metrics-registries", nil, "")
+	return cmd
+}
+
+func TestSetListenerFlagsFromEnv(t *testing.T) {
+	defer unsetListenerEnv(t)()
+
+	var options ListenerOptions
+	cmd := newTestListenCommand(&options)
+	assert.NilError(t, setListenerFlagsFromEnv(cmd))
+	assert.Equal(t, "8080", options.Port)
+	assert.Equal(t, 60*time.Second, options.RequestTimeout)
+
+	os.Setenv(ListenerPortEnvVarName, "9090")
+	os.Setenv(ListenerReadTimeoutEnvVarName, "45s")
+	os.Setenv(ListenerRequestTimeoutEnvVarName, "2m")
+	os.Setenv(common.MetricsRegistriesEnvVarName, "myregistry.azurecr.io,example.com")
+	assert.NilError(t, cmd.Flags().Set("request-timeout", "75s"))
+
+	assert.NilError(t, setListenerFlagsFromEnv(cmd))
+	assert.Equal(t, "9090", options.Port)
+	assert.Equal(t, 45*time.Second, options.ReadTimeout)
+	// Flags take precedence over environment variables
+	assert.Equal(t, 75*time.Second, options.RequestTimeout)
+	assert.DeepEqual(t, []string{"myregistry.azurecr.io", "example.com"}, options.MetricsRegistries)
+
+	os.Setenv(ListenerReadTimeoutEnvVarName, "soon")
+	err := setListenerFlagsFromEnv(newTestListenCommand(&options))
+	assert.ErrorContains(t, err, "Invalid value soon for environment variable LISTENER_READ_TIMEOUT")
+}
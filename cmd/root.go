@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"get.porter.sh/porter/pkg/porter"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg"
@@ -26,6 +29,23 @@ var dogfood bool
 var includeCustomResource bool
 var replaceKubeconfig bool
 var timeout int
+var format string
+var keyVaultOutputs bool
+var profileName string
+var profilesFileName string
+var cloudProfile *common.CloudProfile
+var cloudName string
+var cloudEnvironment *common.CloudEnvironment
+var locationSource string
+var toolVersions common.ToolVersions
+var toolsMirrorURL string
+var pinDigest bool
+var registryConfigFileName string
+var bundleCacheSize int
+var bundleCacheTagTTL time.Duration
+var bundleCacheDir string
+var listenerOptions ListenerOptions
+var logFormat string
 var opts porter.BundlePullOptions
 
 var versionCmd = &cobra.Command{
@@ -39,8 +59,31 @@ var versionCmd = &cobra.Command{
 var listenCmd = &cobra.Command{
 	Use:   "listen",
 	Short: "Starts an http server to listen for request for template generation",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := setListenerFlagsFromEnv(cmd); err != nil {
+			return err
+		}
+		if err := listenerOptions.Validate(); err != nil {
+			return err
+		}
+		if len(registryConfigFileName) == 0 {
+			registryConfigFileName = os.Getenv(common.RegistryConfigEnvVarName)
+		}
+		if err := common.SetRegistryConfigFile(registryConfigFileName); err != nil {
+			return err
+		}
+		// A cache size of zero disables the bundle cache
+		if bundleCacheSize > 0 {
+			cache, err := common.NewBundleCache(bundleCacheSize, bundleCacheTagTTL, bundleCacheDir)
+			if err != nil {
+				return err
+			}
+			common.SetBundleCache(cache)
+		}
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		Listen()
+		Listen(listenerOptions)
 	},
 }
 
@@ -49,7 +92,7 @@ var getbundleCmd = &cobra.Command{
 	Short: "Gets Bundle file for a tag",
 	RunE: func(cmd *cobra.Command, args []string) error {
 
-		bundle, _, err := common.PullBundle(&opts)
+		bundle, _, err := common.PullBundle(&opts, "", nil)
 		if err != nil {
 			return err
 		}
@@ -91,7 +134,61 @@ var rootCmd = &cobra.Command{
 	Use:   "cnabtoarmtemplate",
 	Short: "Generates an ARM template for executing a CNAB package using Azure driver",
 	Long:  `Generates an ARM template which can be used to execute Porter in a deployment script, which in turn executes the CNAB Actions using the CNAB Azure Driver   `,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("log-format") {
+			if format, exists := os.LookupEnv(common.LogFormatEnvVarName); exists {
+				logFormat = format
+			}
+		}
+		if err := common.SetLogFormat(logFormat); err != nil {
+			return err
+		}
+		if len(profilesFileName) == 0 {
+			profilesFileName = os.Getenv(common.CloudProfilesFileEnvVarName)
+		}
+		if len(profilesFileName) > 0 {
+			if err := common.LoadCloudProfiles(profilesFileName); err != nil {
+				return err
+			}
+		}
+		if !cmd.Flags().Changed("locations") {
+			if source, exists := os.LookupEnv(common.LocationsEnvVarName); exists {
+				locationSource = source
+			}
+		}
+		provider, err := common.NewLocationProvider(locationSource, cloudName)
+		if err != nil {
+			return err
+		}
+		common.SetLocationProvider(provider)
+		return nil
+	},
 	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := common.ValidateFormat(format); err != nil {
+			return err
+		}
+		if dogfood && !cmd.Flags().Changed("profile") {
+			profileName = common.DogfoodCloudProfileName
+		}
+		var err error
+		if cloudProfile, err = common.GetCloudProfile(profileName); err != nil {
+			return err
+		}
+		if cloudEnvironment, err = common.GetCloudEnvironment(cloudName); err != nil {
+			return err
+		}
+		if err := toolVersions.Validate(); err != nil {
+			return err
+		}
+		if err := common.ValidateMirrorURL(toolsMirrorURL); err != nil {
+			return err
+		}
+		if pinDigest && len(opts.Tag) == 0 {
+			return errors.New("--pin-digest can only be used with --tag")
+		}
+		if format == common.OutputFormatBicep && !cmd.Flags().Changed("output") {
+			outputFileName = "azuredeploy.bicep"
+		}
 		return common.ValidateTimeout(timeout)
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -123,6 +220,7 @@ var rootCmd = &cobra.Command{
 				OutputWriter:          outputFile,
 				Simplify:              simplify,
 				Timeout:               timeout,
+				Format:                format,
 				GenerateUI:            customUI,
 				CustomRPTemplate:      customRP,
 				IncludeCustomResource: includeCustomResource,
@@ -130,6 +228,12 @@ var rootCmd = &cobra.Command{
 				ReplaceKubeconfig:     replaceKubeconfig,
 				BundlePullOptions:     &opts,
 				ArcTemplate:           arcTemplate,
+				KeyVaultOutputs:       keyVaultOutputs,
+				CloudProfile:          cloudProfile,
+				CloudEnvironment:      cloudEnvironment,
+				ToolVersions:          toolVersions,
+				ToolsMirrorURL:        toolsMirrorURL,
+				PinDigest:             pinDigest,
 			},
 		}
 		err = generator.GenerateFiles(options)
@@ -154,7 +258,7 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	//TODO update CLI options to support managed app, app definition and solution template
-	rootCmd.Flags().StringVarP(&bundleFileName, "file", "f", "bundle.json", "name of bundle file to generate template for , default is bundle.json in the current directory")
+	rootCmd.Flags().StringVarP(&bundleFileName, "file", "f", "bundle.json", "name of bundle file, porter archive or OCI image layout directory to generate template for , default is bundle.json in the current directory")
 	rootCmd.Flags().StringVarP(&outputFileName, "output", "o", "azuredeploy.json", "file name for generated template,default is azuredeploy.json")
 	rootCmd.Flags().BoolVar(&overwrite, "overwrite", false, "specifies if to overwrite the output file if it already exists, default is false")
 	rootCmd.Flags().BoolVarP(&indent, "indent", "i", false, "specifies if the json output should be indented")
@@ -162,14 +266,46 @@ func init() {
 	rootCmd.Flags().BoolVarP(&simplify, "simplify", "s", false, "specifies if the ARM template should be simplified, exposing less parameters and inferring default values")
 	rootCmd.Flags().BoolVarP(&arcTemplate, "arctemplate", "a", false, "generates a template to use a bundle via ARC")
 	rootCmd.Flags().BoolVarP(&dogfood, "dogfood", "d", false, "generates dogfood specific options")
+	if err := rootCmd.Flags().MarkDeprecated("dogfood", "use --profile dogfood instead"); err != nil {
+		log.Infof("Error marking Flag dogfood deprecated: %v", err)
+	}
+	rootCmd.Flags().StringVar(&profileName, "profile", common.DefaultCloudProfileName, "name of the cloud profile that defines the Arc resource provider and portal to use")
+	rootCmd.Flags().StringVar(&cloudName, "cloud", common.AzureCloudName, fmt.Sprintf("name of the Azure cloud environment the template will be deployed to, one of %s", strings.Join(common.GetCloudEnvironmentNames(), ", ")))
+	rootCmd.PersistentFlags().StringVar(&locationSource, "locations", common.LocationSourceDefault, fmt.Sprintf("source of the locations allowed in generated templates, either default, none or the path to a JSON file containing an array of locations or az provider show output, can also be set using the %s environment variable", common.LocationsEnvVarName))
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", common.LogFormatText, fmt.Sprintf("format of log entries, either text or json, can also be set using the %s environment variable", common.LogFormatEnvVarName))
+	rootCmd.PersistentFlags().StringVar(&profilesFileName, "profiles-file", "", fmt.Sprintf("name of a JSON file containing additional cloud profiles, can also be set using the %s environment variable", common.CloudProfilesFileEnvVarName))
 	rootCmd.Flags().BoolVarP(&customRP, "customrp", "p", false, "generates a template to create a custom RP implemenation")
 	rootCmd.Flags().BoolVarP(&includeCustomResource, "includeresource", "n", false, "causes the customRP template to include an instance of the type in addition to the resource and type definition")
 	rootCmd.Flags().BoolVarP(&replaceKubeconfig, "replace", "r", false, "specifies if the ARM template generated should replace Kubeconfig Parameters with AKS references")
+	rootCmd.Flags().StringVar(&format, "format", common.OutputFormatJSON, "specifies the format of the generated template, either json or bicep")
+	rootCmd.Flags().BoolVar(&keyVaultOutputs, "keyvault-outputs", false, "stores sensitive bundle outputs in a key vault created by the generated template and returns the secret URI as the output")
+	rootCmd.Flags().StringVar(&toolVersions.PorterVersion, "porter-version", common.ParameterDefaults["porter_version"].(string), "version of porter used by the generated template")
+	rootCmd.Flags().StringVar(&toolVersions.PorterChecksum, "porter-checksum", "", "SHA-256 checksum of the porter binary, if set the generated template verifies the binary before using it")
+	rootCmd.Flags().StringVar(&toolVersions.AzurePluginVersion, "azure-plugin-version", common.ParameterDefaults["azure_plugin_version"].(string), "version of the porter azure plugin used by the generated template")
+	rootCmd.Flags().StringVar(&toolVersions.AzurePluginChecksum, "azure-plugin-checksum", "", "SHA-256 checksum of the porter azure plugin binary, if set the generated template verifies the binary before using it")
+	rootCmd.Flags().StringVar(&toolVersions.AzureDriverVersion, "driver-version", common.ParameterDefaults["cnab_azure_driver_version"].(string), "version of the cnab-azure-driver used by the generated template")
+	rootCmd.Flags().StringVar(&toolVersions.AzureDriverChecksum, "driver-checksum", "", "SHA-256 checksum of the cnab-azure-driver binary, if set the generated template verifies the binary before using it")
+	rootCmd.Flags().StringVar(&toolsMirrorURL, "mirror-url", "", "base URL of a mirror that the generated template downloads porter, the azure plugin and the cnab-azure-driver from instead of the internet")
 	rootCmd.Flags().IntVar(&timeout, "timeout", 15, "specifies the time in minutes that is allowed for execution of the CNAB Action in the generated template")
 	rootCmd.Flags().StringVarP(&opts.Tag, "tag", "t", "", "Use a bundle specified by the given tag.")
+	rootCmd.Flags().BoolVar(&pinDigest, "pin-digest", false, "resolves the tag to the digest of the bundle and references the bundle by digest in the generated template, the tag is kept in the template metadata")
 	rootCmd.Flags().BoolVar(&opts.Force, "force", false, "Force a fresh pull of the bundle")
 	rootCmd.Flags().BoolVar(&opts.InsecureRegistry, "insecure-registry", false, "Don't require TLS for the registry")
 	rootCmd.AddCommand(versionCmd)
+	listenCmd.Flags().StringVar(&registryConfigFileName, "registry-config", "", fmt.Sprintf("name of a docker config file containing registry credentials used to pull bundles, can also be set using the %s environment variable", common.RegistryConfigEnvVarName))
+	listenCmd.Flags().IntVar(&bundleCacheSize, "cache-size", common.DefaultBundleCacheSize, "maximum number of pulled bundles that are cached in memory and in the cache directory, 0 disables the bundle cache")
+	listenCmd.Flags().DurationVar(&bundleCacheTagTTL, "cache-tag-ttl", common.DefaultBundleCacheTagTTL, "time that the digest a bundle tag resolves to is cached for before the tag is resolved again")
+	listenCmd.Flags().StringVar(&bundleCacheDir, "cache-dir", "", "directory that pulled bundles are cached in so that the cache survives a restart, if not set bundles are only cached in memory")
+	listenCmd.Flags().StringVar(&listenerOptions.Port, "port", "8080", fmt.Sprintf("port that the listener listens on, can also be set using the %s environment variable", ListenerPortEnvVarName))
+	listenCmd.Flags().DurationVar(&listenerOptions.ReadTimeout, "read-timeout", 30*time.Second, fmt.Sprintf("maximum time to read a request, 0 means no timeout, can also be set using the %s environment variable", ListenerReadTimeoutEnvVarName))
+	listenCmd.Flags().DurationVar(&listenerOptions.WriteTimeout, "write-timeout", 90*time.Second, fmt.Sprintf("maximum time from the end of reading a request to the end of writing the response, 0 means no timeout, can also be set using the %s environment variable", ListenerWriteTimeoutEnvVarName))
+	listenCmd.Flags().DurationVar(&listenerOptions.IdleTimeout, "idle-timeout", 120*time.Second, fmt.Sprintf("maximum time to wait for the next request on a keep-alive connection, 0 means the read timeout is used, can also be set using the %s environment variable", ListenerIdleTimeoutEnvVarName))
+	listenCmd.Flags().DurationVar(&listenerOptions.RequestTimeout, "request-timeout", 60*time.Second, fmt.Sprintf("maximum time a request is allowed to be processed for before it is cancelled, must not exceed the write timeout, 0 means no timeout, can also be set using the %s environment variable", ListenerRequestTimeoutEnvVarName))
+	listenCmd.Flags().DurationVar(&listenerOptions.ShutdownTimeout, "shutdown-timeout", 30*time.Second, fmt.Sprintf("maximum time to wait for requests in progress to complete when the listener receives SIGINT or SIGTERM, can also be set using the %s environment variable", ListenerShutdownTimeoutEnvVarName))
+	listenCmd.Flags().StringVar(&listenerOptions.TLSCertFile, "tls-cert-file", "", fmt.Sprintf("name of a PEM encoded TLS certificate file, if set with --tls-key-file the listener uses TLS and reloads the certificate when the file changes, can also be set using the %s environment variable", ListenerTLSCertFileEnvVarName))
+	listenCmd.Flags().StringVar(&listenerOptions.TLSKeyFile, "tls-key-file", "", fmt.Sprintf("name of the PEM encoded key file of the TLS certificate, can also be set using the %s environment variable", ListenerTLSKeyFileEnvVarName))
+	listenCmd.Flags().StringVar(&listenerOptions.CanaryRegistry, "canary-registry", "", fmt.Sprintf("host name of a registry that must be reachable for the readiness endpoint to report that the listener is ready, can also be set using the %s environment variable", common.CanaryRegistryEnvVarName))
+	listenCmd.Flags().StringSliceVar(&listenerOptions.MetricsRegistries, "metrics-registries", nil, fmt.Sprintf("comma separated list of registry host names that bundle pull metrics are recorded for in addition to %s and the canary registry, pulls from other registries are recorded with the registry other, can also be set using the %s environment variable", strings.Join(common.DefaultMetricsRegistries, ", "), common.MetricsRegistriesEnvVarName))
 	rootCmd.AddCommand(listenCmd)
 	getbundleCmd.Flags().StringVarP(&bundleFileName, "file", "f", "bundle.json", "name of bundle file to write , default is bundle.json in the current directory")
 	getbundleCmd.Flags().BoolVar(&overwrite, "overwrite", false, "specifies if to overwrite the output file if it already exists, default is false")
@@ -2,24 +2,81 @@ package common
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"get.porter.sh/porter/pkg/porter"
 	"github.com/cnabio/cnab-go/bundle"
 	"github.com/cnabio/cnab-to-oci/relocation"
 	"github.com/cnabio/cnab-to-oci/remotes"
-	"github.com/docker/cli/cli/config"
+	containerdremotes "github.com/containerd/containerd/remotes"
 	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 )
 
+const (
+	// OutputFormatJSON specifies that the template should be written as an ARM JSON template
+	OutputFormatJSON = "json"
+	// OutputFormatBicep specifies that the template should be written as Bicep
+	OutputFormatBicep = "bicep"
+)
+
+// bundlePulls coalesces concurrent requests for the same bundle
+var bundlePulls singleflight.Group
+
+// pullRemoteBundle pulls a bundle and its relocation map from a registry, it is a variable so that tests can replace the registry
+var pullRemoteBundle = remotes.Pull
+
 var BuiltInActions = []string{
 	"install",
 	"upgrade",
 	"uninstall",
 }
 
+// ToolVersions defines the versions of the tools downloaded by the deployment script and the optional SHA-256 checksums used to verify them
+type ToolVersions struct {
+	PorterVersion       string
+	PorterChecksum      string
+	AzurePluginVersion  string
+	AzurePluginChecksum string
+	AzureDriverVersion  string
+	AzureDriverChecksum string
+}
+
+// WithDefaults returns a copy of the tool versions with the default version set for any tool that does not have a version
+func (versions ToolVersions) WithDefaults() ToolVersions {
+	if versions.PorterVersion == "" {
+		versions.PorterVersion = ParameterDefaults["porter_version"].(string)
+	}
+	if versions.AzurePluginVersion == "" {
+		versions.AzurePluginVersion = ParameterDefaults["azure_plugin_version"].(string)
+	}
+	if versions.AzureDriverVersion == "" {
+		versions.AzureDriverVersion = ParameterDefaults["cnab_azure_driver_version"].(string)
+	}
+	return versions
+}
+
+// Validate checks that the checksums are valid SHA-256 checksums
+func (versions ToolVersions) Validate() error {
+	if err := ValidateChecksum(versions.PorterChecksum); err != nil {
+		return fmt.Errorf("Invalid porter checksum: %w", err)
+	}
+	if err := ValidateChecksum(versions.AzurePluginChecksum); err != nil {
+		return fmt.Errorf("Invalid azure plugin checksum: %w", err)
+	}
+	if err := ValidateChecksum(versions.AzureDriverChecksum); err != nil {
+		return fmt.Errorf("Invalid cnab-azure-driver checksum: %w", err)
+	}
+	return nil
+}
+
 type Options struct {
 	OutputWriter          io.Writer
 	Indent                bool
@@ -31,9 +88,29 @@ type Options struct {
 	ArcTemplate           bool
 	Debug                 bool
 	Timeout               int
+	Format                string
 	UIWriter              io.Writer
 	BundlePullOptions     *porter.BundlePullOptions
-	Dogfood               bool
+	CloudProfile          *CloudProfile
+	CloudEnvironment      *CloudEnvironment
+	KeyVaultOutputs       bool
+	ToolVersions          ToolVersions
+	ToolsMirrorURL        string
+	PinDigest             bool
+	RegistryRefreshToken  string
+	Logger                *log.Entry
+}
+
+// getLogger returns the logger used when getting the bundle, if Logger is not set the standard logger is used
+func (options Options) getLogger() *log.Entry {
+	return loggerOrDefault(options.Logger)
+}
+
+func loggerOrDefault(logger *log.Entry) *log.Entry {
+	if logger == nil {
+		return log.NewEntry(log.StandardLogger())
+	}
+	return logger
 }
 
 // BundleDetails is defines the bundle and bundle options to be used
@@ -42,27 +119,38 @@ type BundleDetails struct {
 	Options
 }
 
-func GetBundleDetails(options BundleDetails) (*bundle.Bundle, string, error) {
+// GetBundleDetails gets the bundle, the bundle tag and the relocation map of the bundle, the relocation map is nil if the bundle has not been relocated.
+// If PinDigest is set or the bundle has been relocated the bundle tag is the digested reference of the bundle rather than the tag that was pulled
+func GetBundleDetails(options BundleDetails) (*bundle.Bundle, string, relocation.ImageRelocationMap, error) {
 	useTag := false
 
 	if options.BundlePullOptions.Tag != "" {
 		useTag = true
 	}
 
-	bundle, err := getBundleFromTagOrFile(options.BundleLoc, useTag, options.BundlePullOptions)
-	if err != nil {
-		return nil, "", err
+	if !useTag && IsLocalBundleSource(options.BundleLoc) {
+		bundle, bundleTag, err := GetBundleFromLocalSource(options.BundleLoc)
+		return bundle, bundleTag, nil, err
 	}
 
-	bundleTag := options.BundlePullOptions.Tag
-	if !useTag {
-		var err error
-		bundleTag, err = getBundleTag(bundle)
+	if useTag {
+		bundle, bundleRef, relocationMap, err := GetBundleFromTag(options.Options)
 		if err != nil {
-			return nil, "", err
+			return nil, "", nil, err
 		}
+		return bundle, bundleRef, relocationMap, nil
 	}
-	return bundle, bundleTag, nil
+
+	bundle, err := getBundleFromFile(options.BundleLoc)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	bundleTag, err := getBundleTag(bundle)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return bundle, bundleTag, nil, nil
 }
 
 func getBundleTag(bundle *bundle.Bundle) (string, error) {
@@ -92,21 +180,25 @@ func getBundleTag(bundle *bundle.Bundle) (string, error) {
 	return "", fmt.Errorf("Cannot get bundle name from invocationImages: %v", bundle.InvocationImages)
 }
 
-func GetBundleFromTag(bundleOptions *porter.BundlePullOptions) (*bundle.Bundle, error) {
-	// TODO deal with relocationMap
-	bun, _, err := PullBundle(bundleOptions)
+// GetBundleFromTag pulls a bundle and its relocation map, the relocation map is nil if the bundle has not been relocated.
+// The reference returned is the tag that was pulled or, if PinDigest is set or the bundle has been relocated, the digested reference that the tag resolved to
+func GetBundleFromTag(options Options) (*bundle.Bundle, string, relocation.ImageRelocationMap, error) {
+	bundleOptions := options.BundlePullOptions
+	bun, relocationMap, pinnedRef, err := PullPinnedBundle(bundleOptions, options.RegistryRefreshToken, options.getLogger())
 	if err != nil {
-		return nil, fmt.Errorf("Unable to pull bundle with tag: %s. %w", bundleOptions.Tag, err)
+		return nil, "", nil, fmt.Errorf("Unable to pull bundle with tag: %s. %w", bundleOptions.Tag, err)
 	}
-	return &bun, nil
-}
 
-func getBundleFromTagOrFile(source string, useTag bool, bundleOptions *porter.BundlePullOptions) (*bundle.Bundle, error) {
-	if useTag {
-		return GetBundleFromTag(bundleOptions)
+	if relocationMap == nil {
+		bundleRef := bundleOptions.Tag
+		if options.PinDigest {
+			bundleRef = pinnedRef
+		}
+		return &bun, bundleRef, nil, nil
 	}
 
-	return getBundleFromFile(source)
+	// porter reads the relocation map from the bundle when it pulls it, a relocated bundle is referenced by digest so that the template always deploys the bundle whose relocated images were read even if the tag is pushed again
+	return &bun, pinnedRef, *relocationMap, nil
 }
 
 func getBundleFromFile(source string) (*bundle.Bundle, error) {
@@ -122,21 +214,181 @@ func getBundleFromFile(source string) (*bundle.Bundle, error) {
 	return &bun, nil
 }
 
-func PullBundle(bundlePullOptions *porter.BundlePullOptions) (bundle.Bundle, *relocation.ImageRelocationMap, error) {
+// PullBundle pulls a bundle and its relocation map, registryRefreshToken is an optional OAuth2 refresh token for the registry that overrides any other registry credentials.
+// The bundle cache is used if it is enabled unless Force is set in the pull options, logger is used to log problems with the bundle cache and if it is nil the standard logger is used
+func PullBundle(bundlePullOptions *porter.BundlePullOptions, registryRefreshToken string, logger *log.Entry) (bundle.Bundle, *relocation.ImageRelocationMap, error) {
+	bun, reloMap, _, err := acquireBundle(bundlePullOptions, registryRefreshToken, loggerOrDefault(logger))
+	return bun, reloMap, err
+}
+
+// PullPinnedBundle resolves the tag of a bundle to the digest of its manifest and pulls the bundle using the digest so that the bundle cannot change if the tag is updated.
+// It returns the bundle, its relocation map and the digested reference of the bundle
+func PullPinnedBundle(bundlePullOptions *porter.BundlePullOptions, registryRefreshToken string, logger *log.Entry) (bundle.Bundle, *relocation.ImageRelocationMap, string, error) {
+	return acquireBundle(bundlePullOptions, registryRefreshToken, loggerOrDefault(logger))
+}
+
+// acquireBundle gets a bundle from the bundle cache or the registry, concurrent requests for the same bundle with the same options are coalesced into a single request and any error is returned to every caller.
+// The digested reference of the bundle is also returned, the bundle cache logs to the logger of the caller whose request is made
+func acquireBundle(bundlePullOptions *porter.BundlePullOptions, registryRefreshToken string, logger *log.Entry) (bundle.Bundle, *relocation.ImageRelocationMap, string, error) {
+	ref, resolver, err := getBundleResolver(bundlePullOptions, registryRefreshToken)
+	if err != nil {
+		return bundle.Bundle{}, nil, "", err
+	}
+	return getSharedBundle(ref, resolver, bundlePullOptions, registryRefreshToken, logger)
+}
+
+// getSharedBundle gets a bundle using getBundle, concurrent calls for the same bundle with the same options share a single call and each caller gets its own copy of the bundle
+func getSharedBundle(ref reference.Named, resolver containerdremotes.Resolver, bundlePullOptions *porter.BundlePullOptions, registryRefreshToken string, logger *log.Entry) (bundle.Bundle, *relocation.ImageRelocationMap, string, error) {
+	// The key includes every option that changes how the bundle is acquired and the credentials so that callers only share results they could have got themselves
+	key := fmt.Sprintf("%s|force=%t|insecure=%t", bundleCacheTagKey(ref.String(), registryRefreshToken), bundlePullOptions.Force, bundlePullOptions.InsecureRegistry)
+	result, err, shared := bundlePulls.Do(key, func() (interface{}, error) {
+		bun, reloMap, bundleRef, err := getBundle(ref, resolver, bundlePullOptions, registryRefreshToken, logger)
+		if err != nil {
+			return nil, err
+		}
+		acquired := acquiredBundle{
+			cachedBundle: cachedBundle{Bundle: bun},
+			ref:          bundleRef,
+		}
+		if reloMap != nil {
+			acquired.RelocationMap = *reloMap
+		}
+		return &acquired, nil
+	})
+	if err != nil {
+		return bundle.Bundle{}, nil, "", err
+	}
+
+	acquired := result.(*acquiredBundle)
+	// Callers that shared a result each get their own copy of the bundle so that they can modify it
+	if shared {
+		if acquired, err = acquired.copy(); err != nil {
+			return bundle.Bundle{}, nil, "", err
+		}
+	}
+
+	if len(acquired.RelocationMap) == 0 {
+		return acquired.Bundle, nil, acquired.ref, nil
+	}
+	return acquired.Bundle, &acquired.RelocationMap, acquired.ref, nil
+}
+
+// acquiredBundle is the result of acquiring a bundle that is shared by concurrent callers
+type acquiredBundle struct {
+	cachedBundle
+	ref string
+}
+
+func (acquired *acquiredBundle) copy() (*acquiredBundle, error) {
+	data, err := json.Marshal(acquired.cachedBundle)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to copy bundle: %w", err)
+	}
+
+	copied := acquiredBundle{ref: acquired.ref}
+	if err := json.Unmarshal(data, &copied.cachedBundle); err != nil {
+		return nil, fmt.Errorf("Unable to copy bundle: %w", err)
+	}
+	return &copied, nil
+}
+
+// getBundle gets a bundle from the bundle cache or the registry, the tag is resolved to a digest and the bundle is pulled by digest so that the digested reference of the bundle can be returned
+func getBundle(ref reference.Named, resolver containerdremotes.Resolver, bundlePullOptions *porter.BundlePullOptions, registryRefreshToken string, logger *log.Entry) (bundle.Bundle, *relocation.ImageRelocationMap, string, error) {
+	cache := bundleCache
+	tagKey := bundleCacheTagKey(ref.String(), registryRefreshToken)
+	if cache != nil && !bundlePullOptions.Force {
+		if bundleDigest, ok := cache.getDigest(tagKey); ok {
+			if cached, ok := cache.get(logger, bundleCacheKey(ref, bundleDigest)); ok {
+				bundleCacheHits.Inc()
+				return fromCachedBundle(ref, bundleDigest, cached)
+			}
+		}
+	}
+
+	start := time.Now()
+	_, descriptor, err := resolver.Resolve(context.Background(), ref.String())
+	if err != nil {
+		err = classifyRegistryError(fmt.Errorf("Unable to resolve digest of bundle %s %w", bundlePullOptions.Tag, err), helpers.ErrorKindRegistryUnavailable)
+		// A tag that cannot be resolved is a failed pull
+		recordBundlePull(reference.Domain(ref), start, err)
+		return bundle.Bundle{}, nil, "", err
+	}
+
+	pinnedRef, err := reference.WithDigest(reference.TrimNamed(ref), descriptor.Digest)
+	if err != nil {
+		return bundle.Bundle{}, nil, "", fmt.Errorf("Invalid digest %s for bundle %s %w", descriptor.Digest, bundlePullOptions.Tag, err)
+	}
+
+	if cache != nil {
+		cache.setDigest(tagKey, descriptor.Digest)
+		if !bundlePullOptions.Force {
+			if cached, ok := cache.get(logger, bundleCacheKey(ref, descriptor.Digest)); ok {
+				bundleCacheHits.Inc()
+				return fromCachedBundle(ref, descriptor.Digest, cached)
+			}
+		}
+		bundleCacheMisses.Inc()
+	}
+
+	bun, reloMap, err := pullBundle(pinnedRef, resolver)
+	if err != nil {
+		return bundle.Bundle{}, nil, "", err
+	}
+
+	if cache != nil {
+		cached := cachedBundle{Bundle: bun}
+		if reloMap != nil {
+			cached.RelocationMap = *reloMap
+		}
+		cache.add(logger, bundleCacheKey(ref, descriptor.Digest), &cached)
+	}
+
+	return bun, reloMap, reference.FamiliarString(pinnedRef), nil
+}
+
+// fromCachedBundle returns the bundle, relocation map and digested reference of a bundle from the bundle cache
+func fromCachedBundle(ref reference.Named, bundleDigest digest.Digest, cached *cachedBundle) (bundle.Bundle, *relocation.ImageRelocationMap, string, error) {
+	pinnedRef, err := reference.WithDigest(reference.TrimNamed(ref), bundleDigest)
+	if err != nil {
+		return bundle.Bundle{}, nil, "", fmt.Errorf("Invalid digest %s for bundle %s %w", bundleDigest, ref, err)
+	}
+
+	if len(cached.RelocationMap) == 0 {
+		return cached.Bundle, nil, reference.FamiliarString(pinnedRef), nil
+	}
+	return cached.Bundle, &cached.RelocationMap, reference.FamiliarString(pinnedRef), nil
+}
+
+func getBundleResolver(bundlePullOptions *porter.BundlePullOptions, registryRefreshToken string) (reference.Named, containerdremotes.Resolver, error) {
 	ref, err := reference.ParseNormalizedNamed(bundlePullOptions.Tag)
 	if err != nil {
-		return bundle.Bundle{}, nil, fmt.Errorf("Invalid bundle tag format %s, expected REGISTRY/name:tag %w", bundlePullOptions.Tag, err)
+		return nil, nil, helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Invalid bundle tag format %s, expected REGISTRY/name:tag %w", bundlePullOptions.Tag, err))
 	}
 
+	reg := reference.Domain(ref)
 	var insecureRegistries []string
 	if bundlePullOptions.InsecureRegistry {
-		reg := reference.Domain(ref)
 		insecureRegistries = append(insecureRegistries, reg)
 	}
 
-	bun, reloMap, err := remotes.Pull(context.Background(), ref, remotes.CreateResolver(config.LoadDefaultConfigFile(os.Stderr), insecureRegistries...))
+	cfg, err := getRegistryConfig(reg, registryRefreshToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ref, remotes.CreateResolver(cfg, insecureRegistries...), nil
+}
+
+func pullBundle(ref reference.Named, resolver containerdremotes.Resolver) (bundle.Bundle, *relocation.ImageRelocationMap, error) {
+	start := time.Now()
+	bun, reloMap, err := pullRemoteBundle(context.Background(), ref, resolver)
+	if err != nil {
+		// Errors that are not caused by the registry are caused by the content of the bundle
+		err = classifyRegistryError(fmt.Errorf("Unable to pull remote bundle %w", err), helpers.ErrorKindInvalidBundle)
+	}
+	recordBundlePull(reference.Domain(ref), start, err)
 	if err != nil {
-		return bundle.Bundle{}, nil, fmt.Errorf("Unable to pull remote bundle %w", err)
+		return bundle.Bundle{}, nil, err
 	}
 
 	if len(reloMap) == 0 {
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"get.porter.sh/porter/pkg/porter"
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	"gotest.tools/assert"
+)
+
+// sharedBundleResult is the result of a call to getSharedBundle
+type sharedBundleResult struct {
+	bundle bundle.Bundle
+	ref    string
+	err    error
+}
+
+// getSharedBundleConcurrently calls getSharedBundle from callers goroutines while the pull is blocked, the pull is released once the callers have had time to wait for it
+func getSharedBundleConcurrently(t *testing.T, puller *stubPuller, callers int) []sharedBundleResult {
+	ref, err := reference.ParseNormalizedNamed("example.com/test:v1")
+	assert.NilError(t, err)
+	resolver := &stubResolver{digest: digest.FromString("bundle")}
+
+	results := make([]sharedBundleResult, callers)
+	var started, finished sync.WaitGroup
+	started.Add(callers)
+	finished.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func(i int) {
+			defer finished.Done()
+			started.Done()
+			bun, _, bundleRef, err := getSharedBundle(ref, resolver, &porter.BundlePullOptions{Tag: "example.com/test:v1"}, "", testLogger)
+			results[i] = sharedBundleResult{bundle: bun, ref: bundleRef, err: err}
+		}(i)
+	}
+
+	started.Wait()
+	time.Sleep(100 * time.Millisecond)
+	close(puller.release)
+	finished.Wait()
+
+	return results
+}
+
+func TestGetSharedBundleCoalescesConcurrentPulls(t *testing.T) {
+	puller := &stubPuller{release: make(chan struct{})}
+	defer useStubRegistry(puller, nil)()
+
+	results := getSharedBundleConcurrently(t, puller, 10)
+
+	assert.Equal(t, 1, puller.pullCount())
+	for _, result := range results {
+		assert.NilError(t, result.err)
+		assert.Equal(t, "test", result.bundle.Name)
+		assert.Equal(t, "example.com/test@"+digest.FromString("bundle").String(), result.ref)
+	}
+
+	// Each caller gets its own copy of the bundle so a caller that modifies its bundle does not change the bundle of any other caller
+	results[0].bundle.Name = "modified"
+	results[0].bundle.InvocationImages[0].Image = "example.com/modified:v1"
+	for _, result := range results[1:] {
+		assert.Equal(t, "test", result.bundle.Name)
+		assert.Equal(t, "example.com/test-installer:v1", result.bundle.InvocationImages[0].Image)
+	}
+}
+
+func TestGetSharedBundleReturnsErrorToEveryCaller(t *testing.T) {
+	puller := &stubPuller{release: make(chan struct{}), err: errors.New("pull failed")}
+	defer useStubRegistry(puller, nil)()
+
+	results := getSharedBundleConcurrently(t, puller, 10)
+
+	assert.Equal(t, 1, puller.pullCount())
+	for _, result := range results {
+		assert.ErrorContains(t, result.err, "pull failed")
+	}
+}
+
+func TestToolVersionsWithDefaults(t *testing.T) {
+	versions := ToolVersions{AzurePluginVersion: "latest"}.WithDefaults()
+
+	// The default versions are concrete versions, latest is only used if it is requested
+	assert.Equal(t, DefaultPorterVersion, versions.PorterVersion)
+	assert.Equal(t, "latest", versions.AzurePluginVersion)
+	assert.Equal(t, DefaultAzureDriverVersion, versions.AzureDriverVersion)
+	for _, version := range []string{DefaultPorterVersion, DefaultAzurePluginVersion, DefaultAzureDriverVersion} {
+		assert.Assert(t, version != "latest")
+	}
+}
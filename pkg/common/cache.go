@@ -0,0 +1,313 @@
+package common
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/cnabio/cnab-to-oci/relocation"
+	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultBundleCacheSize is the default maximum number of bundles held in the bundle cache
+	DefaultBundleCacheSize = 100
+	// DefaultBundleCacheTagTTL is the default time that the digest a tag resolved to is cached for
+	DefaultBundleCacheTagTTL = 5 * time.Minute
+	// bundleCacheFileExtension is the extension of the bundle files in the bundle cache directory
+	bundleCacheFileExtension = ".json"
+)
+
+var (
+	bundleCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cnabarm",
+		Subsystem: "bundle_cache",
+		Name:      "hits_total",
+		Help:      "The number of bundle pulls that were served from the bundle cache.",
+	})
+	bundleCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cnabarm",
+		Subsystem: "bundle_cache",
+		Name:      "misses_total",
+		Help:      "The number of bundle pulls that were not served from the bundle cache, including pulls that bypassed the cache.",
+	})
+)
+
+// cachedBundle is a bundle and its relocation map stored in the bundle cache
+type cachedBundle struct {
+	Bundle        bundle.Bundle                 `json:"bundle"`
+	RelocationMap relocation.ImageRelocationMap `json:"relocationMap,omitempty"`
+}
+
+// cachedTag is the digest that a tag resolved to and the time that the entry expires
+type cachedTag struct {
+	digest  digest.Digest
+	expires time.Time
+}
+
+// bundleCacheEntry is an entry in the in memory LRU list of bundles, the bundle is stored serialised so that each caller gets its own copy of the bundle
+type bundleCacheEntry struct {
+	key  string
+	data []byte
+}
+
+// BundleCache caches pulled bundles by repository and digest in memory and optionally on disk, both are bounded by the maximum number of bundles and the least recently used bundles are evicted.
+// Bundles are keyed by repository as well as digest as the relocation map of a bundle depends on the repository that it was pulled from.
+// The digest that each tag resolves to is cached for a limited time so that updates to the tag are picked up
+type BundleCache struct {
+	mutex      sync.Mutex
+	diskMutex  sync.Mutex
+	maxEntries int
+	tagTTL     time.Duration
+	dir        string
+	tags       map[string]cachedTag
+	entries    map[string]*list.Element
+	lru        *list.List
+	now        func() time.Time
+}
+
+var bundleCache *BundleCache
+
+// NewBundleCache creates a BundleCache that holds up to maxEntries bundles and caches the digest that a tag resolves to for tagTTL.
+// If dir is not empty bundles are also stored in dir so that they survive a restart
+func NewBundleCache(maxEntries int, tagTTL time.Duration, dir string) (*BundleCache, error) {
+	if maxEntries <= 0 {
+		return nil, fmt.Errorf("Invalid bundle cache size %d, the size must be greater than zero", maxEntries)
+	}
+
+	if len(dir) > 0 {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("Unable to create bundle cache directory: %s. %w", dir, err)
+		}
+	}
+
+	return &BundleCache{
+		maxEntries: maxEntries,
+		tagTTL:     tagTTL,
+		dir:        dir,
+		tags:       make(map[string]cachedTag),
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}, nil
+}
+
+// SetBundleCache sets the cache used when pulling bundles, if cache is nil bundles are always pulled from the registry
+func SetBundleCache(cache *BundleCache) {
+	bundleCache = cache
+}
+
+// bundleCacheTagKey returns the key of a tag in the bundle cache, the key includes a hash of the registry token so that a bundle pulled with one set of credentials is not returned to requests with different credentials without the tag being resolved again
+func bundleCacheTagKey(tag string, registryRefreshToken string) string {
+	if len(registryRefreshToken) == 0 {
+		return tag
+	}
+	hash := sha256.Sum256([]byte(registryRefreshToken))
+	return fmt.Sprintf("%s#%s", tag, hex.EncodeToString(hash[:]))
+}
+
+// bundleCacheKey returns the key of a bundle in the bundle cache, the digested reference of the bundle in the repository that it was pulled from
+func bundleCacheKey(ref reference.Named, bundleDigest digest.Digest) string {
+	return fmt.Sprintf("%s@%s", ref.Name(), bundleDigest)
+}
+
+// getDigest returns the digest that a tag resolved to if the entry has not expired
+func (cache *BundleCache) getDigest(tagKey string) (digest.Digest, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	tag, ok := cache.tags[tagKey]
+	if !ok {
+		return "", false
+	}
+	if cache.now().After(tag.expires) {
+		delete(cache.tags, tagKey)
+		return "", false
+	}
+	return tag.digest, true
+}
+
+// setDigest records the digest that a tag resolved to
+func (cache *BundleCache) setDigest(tagKey string, bundleDigest digest.Digest) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.tags[tagKey] = cachedTag{
+		digest:  bundleDigest,
+		expires: cache.now().Add(cache.tagTTL),
+	}
+}
+
+// get returns the bundle with a key from memory or, if it is not in memory, from disk, problems reading the bundle are logged using logger
+func (cache *BundleCache) get(logger *log.Entry, bundleKey string) (*cachedBundle, bool) {
+	var data []byte
+	cache.mutex.Lock()
+	if element, ok := cache.entries[bundleKey]; ok {
+		cache.lru.MoveToFront(element)
+		data = element.Value.(*bundleCacheEntry).data
+	}
+	cache.mutex.Unlock()
+
+	if data == nil {
+		var ok bool
+		if data, ok = cache.readFromDisk(logger, bundleKey); !ok {
+			return nil, false
+		}
+		cache.addToMemory(bundleKey, data)
+	}
+
+	var cached cachedBundle
+	if err := json.Unmarshal(data, &cached); err != nil {
+		logger.Infof("Failed to deserialise bundle %s from the bundle cache: %v", bundleKey, err)
+		return nil, false
+	}
+
+	return &cached, true
+}
+
+// add stores a bundle in memory and on disk, problems writing the bundle are logged using logger
+func (cache *BundleCache) add(logger *log.Entry, bundleKey string, cached *cachedBundle) {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		logger.Infof("Failed to serialise bundle %s for the bundle cache: %v", bundleKey, err)
+		return
+	}
+
+	cache.addToMemory(bundleKey, data)
+	cache.writeToDisk(logger, bundleKey, data)
+}
+
+func (cache *BundleCache) addToMemory(bundleKey string, data []byte) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, ok := cache.entries[bundleKey]; ok {
+		element.Value.(*bundleCacheEntry).data = data
+		cache.lru.MoveToFront(element)
+		return
+	}
+
+	cache.entries[bundleKey] = cache.lru.PushFront(&bundleCacheEntry{
+		key:  bundleKey,
+		data: data,
+	})
+
+	for cache.lru.Len() > cache.maxEntries {
+		oldest := cache.lru.Back()
+		cache.lru.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*bundleCacheEntry).key)
+	}
+}
+
+// diskPath returns the path of the file that a bundle is stored in, the file is named using a hash of the key so that the key cannot be used to access files outside the cache directory
+func (cache *BundleCache) diskPath(bundleKey string) (string, bool) {
+	if len(cache.dir) == 0 {
+		return "", false
+	}
+	hash := sha256.Sum256([]byte(bundleKey))
+	return filepath.Join(cache.dir, hex.EncodeToString(hash[:])+bundleCacheFileExtension), true
+}
+
+func (cache *BundleCache) readFromDisk(logger *log.Entry, bundleKey string) ([]byte, bool) {
+	path, ok := cache.diskPath(bundleKey)
+	if !ok {
+		return nil, false
+	}
+
+	cache.diskMutex.Lock()
+	defer cache.diskMutex.Unlock()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	if !json.Valid(data) {
+		logger.Infof("Removing invalid bundle cache file %s", path)
+		_ = os.Remove(path)
+		return nil, false
+	}
+
+	// The modification time records when the bundle was last used so that the least recently used bundles are evicted from disk
+	now := cache.now()
+	_ = os.Chtimes(path, now, now)
+
+	return data, true
+}
+
+func (cache *BundleCache) writeToDisk(logger *log.Entry, bundleKey string, data []byte) {
+	path, ok := cache.diskPath(bundleKey)
+	if !ok {
+		return
+	}
+
+	cache.diskMutex.Lock()
+	defer cache.diskMutex.Unlock()
+
+	// The bundle is written to a temporary file and renamed so that a partially written file is never read
+	file, err := ioutil.TempFile(cache.dir, "bundle-*.tmp")
+	if err != nil {
+		logger.Infof("Failed to create bundle cache file for bundle %s: %v", bundleKey, err)
+		return
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		logger.Infof("Failed to write bundle cache file for bundle %s: %v", bundleKey, err)
+		_ = os.Remove(file.Name())
+		return
+	}
+	now := cache.now()
+	_ = os.Chtimes(path, now, now)
+
+	cache.evictFromDisk(logger)
+}
+
+// evictFromDisk removes the least recently used bundles from disk when there are more than the maximum number of bundles
+func (cache *BundleCache) evictFromDisk(logger *log.Entry) {
+	files, err := ioutil.ReadDir(cache.dir)
+	if err != nil {
+		logger.Infof("Failed to read bundle cache directory %s: %v", cache.dir, err)
+		return
+	}
+
+	var bundleFiles []os.FileInfo
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), bundleCacheFileExtension) {
+			bundleFiles = append(bundleFiles, file)
+		}
+	}
+
+	if len(bundleFiles) <= cache.maxEntries {
+		return
+	}
+
+	sort.Slice(bundleFiles, func(i, j int) bool {
+		return bundleFiles[i].ModTime().Before(bundleFiles[j].ModTime())
+	})
+
+	for _, file := range bundleFiles[:len(bundleFiles)-cache.maxEntries] {
+		if err := os.Remove(filepath.Join(cache.dir, file.Name())); err != nil {
+			logger.Infof("Failed to remove bundle cache file %s: %v", file.Name(), err)
+		}
+	}
+}
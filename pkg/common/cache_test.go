@@ -0,0 +1,302 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"get.porter.sh/porter/pkg/porter"
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/cnabio/cnab-to-oci/relocation"
+	containerdremotes "github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"gotest.tools/assert"
+)
+
+// testLogger is the logger passed to the bundle cache in tests
+var testLogger = log.NewEntry(log.StandardLogger())
+
+// stubResolver resolves every tag to the same digest and counts the number of times a tag is resolved
+type stubResolver struct {
+	mutex    sync.Mutex
+	digest   digest.Digest
+	err      error
+	resolves int
+}
+
+func (resolver *stubResolver) Resolve(ctx context.Context, ref string) (string, ocispec.Descriptor, error) {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	resolver.resolves++
+	return ref, ocispec.Descriptor{Digest: resolver.digest}, resolver.err
+}
+
+func (resolver *stubResolver) Fetcher(ctx context.Context, ref string) (containerdremotes.Fetcher, error) {
+	return nil, errors.New("Fetcher is not implemented by the stub resolver")
+}
+
+func (resolver *stubResolver) Pusher(ctx context.Context, ref string) (containerdremotes.Pusher, error) {
+	return nil, errors.New("Pusher is not implemented by the stub resolver")
+}
+
+func (resolver *stubResolver) resolveCount() int {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	return resolver.resolves
+}
+
+// stubPuller replaces the registry when pulling bundles, the relocation map of each bundle refers to the repository that the bundle was pulled from
+type stubPuller struct {
+	mutex   sync.Mutex
+	err     error
+	release chan struct{}
+	pulls   int
+}
+
+func (puller *stubPuller) pull(ctx context.Context, ref reference.Named, resolver containerdremotes.Resolver) (*bundle.Bundle, relocation.ImageRelocationMap, error) {
+	puller.mutex.Lock()
+	puller.pulls++
+	puller.mutex.Unlock()
+
+	if puller.release != nil {
+		<-puller.release
+	}
+	if puller.err != nil {
+		return nil, nil, puller.err
+	}
+
+	return &bundle.Bundle{
+			Name:             "test",
+			InvocationImages: []bundle.InvocationImage{{BaseImage: bundle.BaseImage{ImageType: "docker", Image: "example.com/test-installer:v1"}}},
+		},
+		relocation.ImageRelocationMap{"example.com/test-installer:v1": ref.Name() + "@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+		nil
+}
+
+func (puller *stubPuller) pullCount() int {
+	puller.mutex.Lock()
+	defer puller.mutex.Unlock()
+	return puller.pulls
+}
+
+// useStubRegistry replaces the registry and the bundle cache, the function returned restores them
+func useStubRegistry(puller *stubPuller, cache *BundleCache) func() {
+	pull := pullRemoteBundle
+	previousCache := bundleCache
+	pullRemoteBundle = puller.pull
+	SetBundleCache(cache)
+	return func() {
+		pullRemoteBundle = pull
+		SetBundleCache(previousCache)
+	}
+}
+
+// testClock is a clock for the bundle cache that only moves when it is advanced
+type testClock struct {
+	now time.Time
+}
+
+func (clock *testClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *testClock) advance(duration time.Duration) {
+	clock.now = clock.now.Add(duration)
+}
+
+func newTestBundleCache(t *testing.T, maxEntries int, dir string, clock *testClock) *BundleCache {
+	cache, err := NewBundleCache(maxEntries, time.Minute, dir)
+	assert.NilError(t, err)
+	cache.now = clock.Now
+	return cache
+}
+
+func TestNewBundleCacheInvalidSize(t *testing.T) {
+	_, err := NewBundleCache(0, time.Minute, "")
+	assert.ErrorContains(t, err, "Invalid bundle cache size 0")
+}
+
+func TestBundleCacheTagTTL(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	cache := newTestBundleCache(t, 1, "", clock)
+	bundleDigest := digest.FromString("bundle")
+
+	cache.setDigest("example.com/test:v1", bundleDigest)
+
+	clock.advance(59 * time.Second)
+	cached, ok := cache.getDigest("example.com/test:v1")
+	assert.Assert(t, ok)
+	assert.Equal(t, bundleDigest, cached)
+
+	clock.advance(2 * time.Second)
+	_, ok = cache.getDigest("example.com/test:v1")
+	assert.Assert(t, !ok, "the tag should have expired")
+
+	// An expired tag is removed so that it is not returned once the clock is reset
+	clock.advance(-time.Minute)
+	_, ok = cache.getDigest("example.com/test:v1")
+	assert.Assert(t, !ok, "the expired tag should have been removed")
+}
+
+func TestBundleCacheTagKey(t *testing.T) {
+	assert.Equal(t, "example.com/test:v1", bundleCacheTagKey("example.com/test:v1", ""))
+	withToken := bundleCacheTagKey("example.com/test:v1", "token")
+	assert.Assert(t, withToken != bundleCacheTagKey("example.com/test:v1", "other"), "tags pulled with different tokens should have different keys")
+	assert.Assert(t, withToken != bundleCacheTagKey("example.com/test:v1", ""), "tags pulled with and without a token should have different keys")
+}
+
+func TestBundleCacheEvictsLeastRecentlyUsedFromMemory(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	cache := newTestBundleCache(t, 2, "", clock)
+
+	cache.add(testLogger, "example.com/a@sha256:1", &cachedBundle{Bundle: bundle.Bundle{Name: "a"}})
+	cache.add(testLogger, "example.com/b@sha256:2", &cachedBundle{Bundle: bundle.Bundle{Name: "b"}})
+
+	// Using a makes b the least recently used bundle
+	cached, ok := cache.get(testLogger, "example.com/a@sha256:1")
+	assert.Assert(t, ok)
+	assert.Equal(t, "a", cached.Bundle.Name)
+
+	cache.add(testLogger, "example.com/c@sha256:3", &cachedBundle{Bundle: bundle.Bundle{Name: "c"}})
+
+	_, ok = cache.get(testLogger, "example.com/b@sha256:2")
+	assert.Assert(t, !ok, "b should have been evicted")
+	for _, key := range []string{"example.com/a@sha256:1", "example.com/c@sha256:3"} {
+		_, ok := cache.get(testLogger, key)
+		assert.Assert(t, ok, "%s should not have been evicted", key)
+	}
+}
+
+func TestBundleCacheEvictsLeastRecentlyUsedFromDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle-cache")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	clock := &testClock{now: time.Now().Add(-time.Hour)}
+
+	cache := newTestBundleCache(t, 2, dir, clock)
+	cache.add(testLogger, "example.com/a@sha256:1", &cachedBundle{Bundle: bundle.Bundle{Name: "a"}})
+	clock.advance(time.Second)
+	cache.add(testLogger, "example.com/b@sha256:2", &cachedBundle{Bundle: bundle.Bundle{Name: "b"}})
+
+	// A new cache reads the bundles from disk as they are not in memory, using a makes b the least recently used bundle on disk
+	clock.advance(time.Second)
+	restarted := newTestBundleCache(t, 2, dir, clock)
+	cached, ok := restarted.get(testLogger, "example.com/a@sha256:1")
+	assert.Assert(t, ok)
+	assert.Equal(t, "a", cached.Bundle.Name)
+
+	clock.advance(time.Second)
+	restarted.add(testLogger, "example.com/c@sha256:3", &cachedBundle{Bundle: bundle.Bundle{Name: "c"}})
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(files))
+
+	fromDisk := newTestBundleCache(t, 2, dir, clock)
+	_, ok = fromDisk.get(testLogger, "example.com/b@sha256:2")
+	assert.Assert(t, !ok, "b should have been evicted from disk")
+	for _, key := range []string{"example.com/a@sha256:1", "example.com/c@sha256:3"} {
+		_, ok := fromDisk.get(testLogger, key)
+		assert.Assert(t, ok, "%s should not have been evicted from disk", key)
+	}
+}
+
+func TestBundleCacheRemovesInvalidDiskFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle-cache")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	cache := newTestBundleCache(t, 1, dir, &testClock{now: time.Now()})
+
+	path, ok := cache.diskPath("example.com/a@sha256:1")
+	assert.Assert(t, ok)
+	assert.NilError(t, ioutil.WriteFile(path, []byte("not json"), 0600))
+
+	_, ok = cache.get(testLogger, "example.com/a@sha256:1")
+	assert.Assert(t, !ok)
+	_, err = os.Stat(path)
+	assert.Assert(t, os.IsNotExist(err), "the invalid file should have been removed")
+}
+
+func TestBundleCacheLogsToLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle-cache")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	cache := newTestBundleCache(t, 1, dir, &testClock{now: time.Now()})
+
+	path, ok := cache.diskPath("example.com/a@sha256:1")
+	assert.Assert(t, ok)
+	assert.NilError(t, ioutil.WriteFile(path, []byte("not json"), 0600))
+
+	// Problems with the cache are logged using the logger of the request so that they include the request ID
+	var output bytes.Buffer
+	logger := log.New()
+	logger.Out = &output
+	_, ok = cache.get(logger.WithField("request_id", "test"), "example.com/a@sha256:1")
+	assert.Assert(t, !ok)
+	assert.Assert(t, strings.Contains(output.String(), "Removing invalid bundle cache file"), output.String())
+	assert.Assert(t, strings.Contains(output.String(), "request_id=test"), output.String())
+}
+
+func TestGetBundleUsesBundleCache(t *testing.T) {
+	puller := &stubPuller{}
+	resolver := &stubResolver{digest: digest.FromString("bundle")}
+	defer useStubRegistry(puller, newTestBundleCache(t, 10, "", &testClock{now: time.Now()}))()
+
+	ref, err := reference.ParseNormalizedNamed("example.com/test:v1")
+	assert.NilError(t, err)
+	pullOptions := &porter.BundlePullOptions{Tag: "example.com/test:v1"}
+
+	hits := testutil.ToFloat64(bundleCacheHits)
+	misses := testutil.ToFloat64(bundleCacheMisses)
+
+	_, _, bundleRef, err := getBundle(ref, resolver, pullOptions, "", testLogger)
+	assert.NilError(t, err)
+	assert.Equal(t, "example.com/test@"+resolver.digest.String(), bundleRef)
+	assert.Equal(t, 1, puller.pullCount())
+	assert.Equal(t, misses+1, testutil.ToFloat64(bundleCacheMisses))
+
+	// The tag and the bundle are cached so the tag is not resolved again
+	bun, reloMap, bundleRef, err := getBundle(ref, resolver, pullOptions, "", testLogger)
+	assert.NilError(t, err)
+	assert.Equal(t, "test", bun.Name)
+	assert.Equal(t, "example.com/test@sha256:0000000000000000000000000000000000000000000000000000000000000000", (*reloMap)["example.com/test-installer:v1"])
+	assert.Equal(t, "example.com/test@"+resolver.digest.String(), bundleRef)
+	assert.Equal(t, 1, resolver.resolveCount())
+	assert.Equal(t, 1, puller.pullCount())
+	assert.Equal(t, hits+1, testutil.ToFloat64(bundleCacheHits))
+
+	// Force bypasses the cache so the tag is resolved and the bundle is pulled again
+	_, _, _, err = getBundle(ref, resolver, &porter.BundlePullOptions{Tag: "example.com/test:v1", Force: true}, "", testLogger)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, resolver.resolveCount())
+	assert.Equal(t, 2, puller.pullCount())
+	assert.Equal(t, hits+1, testutil.ToFloat64(bundleCacheHits))
+	assert.Equal(t, misses+2, testutil.ToFloat64(bundleCacheMisses))
+}
+
+func TestGetBundleCachesBundlesByRepository(t *testing.T) {
+	puller := &stubPuller{}
+	resolver := &stubResolver{digest: digest.FromString("bundle")}
+	defer useStubRegistry(puller, newTestBundleCache(t, 10, "", &testClock{now: time.Now()}))()
+
+	// The same bundle in two repositories has the same digest but a different relocation map
+	for _, tag := range []string{"example.com/first:v1", "example.com/second:v1"} {
+		ref, err := reference.ParseNormalizedNamed(tag)
+		assert.NilError(t, err)
+
+		_, reloMap, _, err := getBundle(ref, resolver, &porter.BundlePullOptions{Tag: tag}, "", testLogger)
+		assert.NilError(t, err)
+		assert.Equal(t, ref.Name()+"@sha256:0000000000000000000000000000000000000000000000000000000000000000", (*reloMap)["example.com/test-installer:v1"])
+	}
+	assert.Equal(t, 2, puller.pullCount())
+}
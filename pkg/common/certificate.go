@@ -0,0 +1,99 @@
+package common
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CertificateReloader loads a TLS certificate and key from files and reloads them when either file changes so that a renewed certificate is used without a restart
+type CertificateReloader struct {
+	mutex       sync.RWMutex
+	certFile    string
+	keyFile     string
+	certModTime time.Time
+	keyModTime  time.Time
+	certificate *tls.Certificate
+	logger      *log.Entry
+}
+
+// NewCertificateReloader creates a CertificateReloader and loads the certificate and key, reloading the certificate is logged using logger
+func NewCertificateReloader(certFile string, keyFile string, logger *log.Entry) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   loggerOrDefault(logger),
+	}
+
+	certModTime, keyModTime, err := reloader.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	if err := reloader.load(certModTime, keyModTime); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// GetCertificate returns the certificate for a TLS handshake, it is used as the GetCertificate function of a tls.Config.
+// If the certificate or key file has changed they are reloaded, if they cannot be loaded the previous certificate is used
+func (reloader *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certModTime, keyModTime, err := reloader.modTimes()
+	if err != nil {
+		reloader.logger.Infof("Failed to check TLS certificate files for changes: %v", err)
+		return reloader.current(), nil
+	}
+
+	reloader.mutex.RLock()
+	changed := !certModTime.Equal(reloader.certModTime) || !keyModTime.Equal(reloader.keyModTime)
+	reloader.mutex.RUnlock()
+
+	if changed {
+		// The certificate and key may be updated separately so loading fails until both files have been replaced
+		if err := reloader.load(certModTime, keyModTime); err != nil {
+			reloader.logger.Infof("Failed to reload TLS certificate: %v", err)
+		} else {
+			reloader.logger.Infof("Reloaded TLS certificate from %s", reloader.certFile)
+		}
+	}
+
+	return reloader.current(), nil
+}
+
+func (reloader *CertificateReloader) current() *tls.Certificate {
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+	return reloader.certificate
+}
+
+func (reloader *CertificateReloader) load(certModTime time.Time, keyModTime time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	// The modification times are recorded even if loading fails so that the files are only loaded again once they change
+	reloader.certModTime = certModTime
+	reloader.keyModTime = keyModTime
+	if err != nil {
+		return fmt.Errorf("Unable to load TLS certificate: %s and key: %s. %w", reloader.certFile, reloader.keyFile, err)
+	}
+	reloader.certificate = &certificate
+	return nil
+}
+
+func (reloader *CertificateReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(reloader.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Unable to access TLS certificate file: %s. %w", reloader.certFile, err)
+	}
+	keyInfo, err := os.Stat(reloader.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Unable to access TLS key file: %s. %w", reloader.keyFile, err)
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
@@ -0,0 +1,113 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+// writeCertificate writes a self signed certificate and key with the serial number to the files and sets their modification time
+func writeCertificate(t *testing.T, certFile string, keyFile string, serialNumber int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+
+	certTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, certTemplate, certTemplate, &key.PublicKey, key)
+	assert.NilError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NilError(t, err)
+
+	assert.NilError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NilError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	assert.NilError(t, os.Chtimes(certFile, modTime, modTime))
+	assert.NilError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func serialNumberOf(t *testing.T, certificate *tls.Certificate) int64 {
+	assert.Assert(t, certificate != nil)
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	assert.NilError(t, err)
+	return parsed.SerialNumber.Int64()
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificate")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	modTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	writeCertificate(t, certFile, keyFile, 1, modTime)
+
+	reloader, err := NewCertificateReloader(certFile, keyFile, testLogger)
+	assert.NilError(t, err)
+
+	certificate, err := reloader.GetCertificate(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, int64(1), serialNumberOf(t, certificate))
+
+	// A renewed certificate is used once the files change
+	modTime = modTime.Add(time.Second)
+	writeCertificate(t, certFile, keyFile, 2, modTime)
+	certificate, err = reloader.GetCertificate(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, int64(2), serialNumberOf(t, certificate))
+
+	// The previous certificate is used while the new files cannot be loaded
+	modTime = modTime.Add(time.Second)
+	assert.NilError(t, ioutil.WriteFile(keyFile, []byte("not a key"), 0600))
+	assert.NilError(t, os.Chtimes(keyFile, modTime, modTime))
+	certificate, err = reloader.GetCertificate(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, int64(2), serialNumberOf(t, certificate))
+
+	modTime = modTime.Add(time.Second)
+	writeCertificate(t, certFile, keyFile, 3, modTime)
+	certificate, err = reloader.GetCertificate(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, int64(3), serialNumberOf(t, certificate))
+
+	// The previous certificate is used while the files cannot be accessed
+	assert.NilError(t, os.Remove(certFile))
+	certificate, err = reloader.GetCertificate(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, int64(3), serialNumberOf(t, certificate))
+}
+
+func TestNewCertificateReloaderFailsWithInvalidFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificate")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	_, err = NewCertificateReloader(certFile, keyFile, testLogger)
+	assert.ErrorContains(t, err, "Unable to access TLS certificate file")
+
+	writeCertificate(t, certFile, keyFile, 1, time.Now())
+	assert.NilError(t, ioutil.WriteFile(keyFile, []byte("not a key"), 0600))
+	_, err = NewCertificateReloader(certFile, keyFile, testLogger)
+	assert.ErrorContains(t, err, "Unable to load TLS certificate")
+}
@@ -0,0 +1,115 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
+)
+
+const (
+	// AzureCloudName is the name of the Azure public cloud environment, this is used when no cloud environment is specified
+	AzureCloudName = "AzureCloud"
+	// AzureUSGovernmentName is the name of the Azure US Government cloud environment
+	AzureUSGovernmentName = "AzureUSGovernment"
+	// AzureChinaCloudName is the name of the Azure China cloud environment
+	AzureChinaCloudName = "AzureChinaCloud"
+)
+
+// CloudEnvironment defines the endpoints and locations of an Azure cloud that generated templates are deployed to
+type CloudEnvironment struct {
+	Name                  string
+	PortalURL             string
+	StorageEndpointSuffix string
+	// ContainerInstanceDNSSuffix is the suffix of the DNS names given to container groups with a public IP address
+	ContainerInstanceDNSSuffix string
+	// Locations is the list of locations in the cloud that the resources in the generated template can be created in
+	Locations []string
+}
+
+// cloudEnvironments are the supported clouds, the locations are the defaults used when the location provider does not supply the locations of a cloud
+var cloudEnvironments = map[string]CloudEnvironment{
+	strings.ToLower(AzureCloudName): {
+		Name:                       AzureCloudName,
+		PortalURL:                  "https://portal.azure.com",
+		StorageEndpointSuffix:      "core.windows.net",
+		ContainerInstanceDNSSuffix: "azurecontainer.io",
+		Locations: []string{
+			"australiaeast",
+			"brazilsouth",
+			"canadacentral",
+			"centralindia",
+			"centralus",
+			"eastasia",
+			"eastus",
+			"eastus2",
+			"francecentral",
+			"japaneast",
+			"koreacentral",
+			"northcentralus",
+			"northeurope",
+			"southcentralus",
+			"southeastasia",
+			"southindia",
+			"uksouth",
+			"westeurope",
+			"westcentralus",
+			"westus",
+			"westus2",
+		},
+	},
+	strings.ToLower(AzureUSGovernmentName): {
+		Name:                       AzureUSGovernmentName,
+		PortalURL:                  "https://portal.azure.us",
+		StorageEndpointSuffix:      "core.usgovcloudapi.net",
+		ContainerInstanceDNSSuffix: "azurecontainer.console.azure.us",
+		Locations: []string{
+			"usgovarizona",
+			"usgovtexas",
+			"usgovvirginia",
+		},
+	},
+	strings.ToLower(AzureChinaCloudName): {
+		Name:                       AzureChinaCloudName,
+		PortalURL:                  "https://portal.azure.cn",
+		StorageEndpointSuffix:      "core.chinacloudapi.cn",
+		ContainerInstanceDNSSuffix: "azurecontainer.console.azure.cn",
+		Locations: []string{
+			"chinaeast2",
+			"chinanorth2",
+		},
+	},
+}
+
+// GetCloudEnvironment returns the cloud environment with the given name, if name is empty the Azure public cloud is returned
+func GetCloudEnvironment(name string) (*CloudEnvironment, error) {
+	if name == "" {
+		name = AzureCloudName
+	}
+
+	cloud, ok := cloudEnvironments[strings.ToLower(name)]
+	if !ok {
+		return nil, helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Cloud environment %s does not exist, available cloud environments are %s", name, strings.Join(GetCloudEnvironmentNames(), ", ")))
+	}
+
+	return &cloud, nil
+}
+
+// GetCloudEnvironmentNames returns the sorted names of the available cloud environments
+func GetCloudEnvironmentNames() []string {
+	var names []string
+	for _, cloud := range cloudEnvironments {
+		names = append(names, cloud.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetPortalURL returns the portal URL for the cloud environment, the cloud profile portal URL is used if it is set
+func GetPortalURL(cloud *CloudEnvironment, profile *CloudProfile) string {
+	if profile != nil && len(profile.PortalURL) > 0 {
+		return profile.PortalURL
+	}
+	return cloud.PortalURL
+}
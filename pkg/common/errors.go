@@ -0,0 +1,83 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
+)
+
+// registryStatusPattern matches the HTTP status of a failed registry request in the errors returned by the registry client
+var registryStatusPattern = regexp.MustCompile(`\b([45][0-9]{2}) [A-Z][A-Za-z ]*`)
+
+// networkErrorMessages are the messages of network errors that are not returned as net.Error once they have been wrapped by the registry client
+var networkErrorMessages = []string{
+	"connection refused",
+	"connection reset",
+	"no such host",
+	"i/o timeout",
+	"tls handshake",
+	"server misbehaving",
+}
+
+// classifyRegistryError classifies an error returned by the registry client when resolving or pulling a bundle, errors that are not caused by the registry or the network are classified as defaultKind
+func classifyRegistryError(err error, defaultKind helpers.ErrorKind) error {
+	if err == nil {
+		return nil
+	}
+
+	var classified *helpers.Error
+	if errors.As(err, &classified) {
+		return err
+	}
+
+	if errors.Is(err, errdefs.ErrNotFound) {
+		return helpers.NewError(helpers.ErrorKindNotFound, err)
+	}
+
+	if errors.Is(err, errdefs.ErrUnavailable) {
+		return helpers.NewError(helpers.ErrorKindRegistryUnavailable, err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return helpers.NewError(helpers.ErrorKindRegistryUnavailable, err)
+	}
+
+	message := strings.ToLower(err.Error())
+	if match := registryStatusPattern.FindStringSubmatch(err.Error()); match != nil {
+		statusCode, _ := strconv.Atoi(match[1])
+		switch {
+		case statusCode == 401:
+			return helpers.NewError(helpers.ErrorKindUnauthorized, err)
+		case statusCode == 403:
+			return helpers.NewError(helpers.ErrorKindForbidden, err)
+		case statusCode == 404:
+			return helpers.NewError(helpers.ErrorKindNotFound, err)
+		case statusCode == 429 || statusCode >= 500:
+			return helpers.NewError(helpers.ErrorKindRegistryUnavailable, err)
+		}
+	}
+
+	// Registries return error codes in the body of failed requests
+	switch {
+	case strings.Contains(message, "unauthorized"):
+		return helpers.NewError(helpers.ErrorKindUnauthorized, err)
+	case strings.Contains(message, "denied"):
+		return helpers.NewError(helpers.ErrorKindForbidden, err)
+	case strings.Contains(message, "manifest unknown"), strings.Contains(message, "name unknown"):
+		return helpers.NewError(helpers.ErrorKindNotFound, err)
+	}
+
+	for _, networkErrorMessage := range networkErrorMessages {
+		if strings.Contains(message, networkErrorMessage) {
+			return helpers.NewError(helpers.ErrorKindRegistryUnavailable, err)
+		}
+	}
+
+	return helpers.NewError(defaultKind, err)
+}
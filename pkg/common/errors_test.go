@@ -0,0 +1,38 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
+	"gotest.tools/assert"
+)
+
+func TestClassifyRegistryError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected helpers.ErrorKind
+	}{
+		{"already classified", helpers.NewError(helpers.ErrorKindInvalidRequest, errors.New("Invalid bundle tag format")), helpers.ErrorKindInvalidRequest},
+		{"not found", fmt.Errorf("docker.io/library/missing:v1: %w", errdefs.ErrNotFound), helpers.ErrorKindNotFound},
+		{"unavailable", fmt.Errorf("registry: %w", errdefs.ErrUnavailable), helpers.ErrorKindRegistryUnavailable},
+		{"unauthorized status", errors.New("unexpected status: 401 Unauthorized"), helpers.ErrorKindUnauthorized},
+		{"forbidden status", errors.New("unexpected status: 403 Forbidden"), helpers.ErrorKindForbidden},
+		{"server error status", errors.New("unexpected status: 503 Service Unavailable"), helpers.ErrorKindRegistryUnavailable},
+		{"denied", errors.New("requested access to the resource is denied"), helpers.ErrorKindForbidden},
+		{"manifest unknown", errors.New("MANIFEST_UNKNOWN: manifest unknown"), helpers.ErrorKindNotFound},
+		{"network", errors.New("dial tcp: lookup example.com: no such host"), helpers.ErrorKindRegistryUnavailable},
+		{"other", errors.New("invalid bundle"), helpers.ErrorKindInvalidBundle},
+	}
+
+	for _, test := range tests {
+		err := classifyRegistryError(test.err, helpers.ErrorKindInvalidBundle)
+		assert.Equal(t, test.expected, helpers.KindOf(err), test.name)
+		assert.Assert(t, errors.Is(err, test.err), test.name)
+	}
+
+	assert.NilError(t, classifyRegistryError(nil, helpers.ErrorKindInternal))
+}
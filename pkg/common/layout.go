@@ -0,0 +1,381 @@
+package common
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/docker/distribution/reference"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
+)
+
+const (
+	// layoutIndexFileName is the name of the index file in an OCI image layout
+	layoutIndexFileName = "index.json"
+	// archiveBundleFileName is the name of the bundle file in a porter archive
+	archiveBundleFileName = "bundle.json"
+	// archiveLayoutPath is the path of the OCI image layout in a porter archive
+	archiveLayoutPath = "artifacts/layout"
+	// containerdImageNameAnnotation is the annotation used by containerd to store the full reference of an image in an OCI image layout
+	containerdImageNameAnnotation = "io.containerd.image.name"
+	// cnabManifestTypeAnnotation is the annotation used by cnab-to-oci to identify the type of each manifest in a bundle index
+	cnabManifestTypeAnnotation = "io.cnab.manifest.type"
+	// cnabManifestTypeConfig is the manifest type of the manifest containing the bundle
+	cnabManifestTypeConfig = "config"
+	// porterInvocationImageSuffix is the suffix that porter adds to the bundle repository to name the invocation image of the bundle
+	porterInvocationImageSuffix = "-installer"
+	// maxArchiveFileSize is the size of the largest file that is read from an archive, larger files are image layers which are not needed to generate templates
+	maxArchiveFileSize = 10 * 1024 * 1024
+)
+
+// layoutReader reads files from an OCI image layout
+type layoutReader func(name string) ([]byte, error)
+
+// IsLocalBundleSource returns true if source is a bundle archive or an OCI image layout directory rather than a bundle file
+func IsLocalBundleSource(source string) bool {
+	info, err := os.Stat(source)
+	if err != nil {
+		return false
+	}
+	if info.IsDir() {
+		return true
+	}
+	return isArchive(source)
+}
+
+// GetBundleFromLocalSource reads a bundle and its reference from a porter archive, an OCI image layout archive or an OCI image layout directory.
+// The bundle reference is resolved from the index of the OCI image layout
+func GetBundleFromLocalSource(source string) (*bundle.Bundle, string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to access bundle source: %s. %w", source, err)
+	}
+
+	if info.IsDir() {
+		if _, err := os.Stat(filepath.Join(source, ocischemav1.ImageLayoutFile)); err != nil {
+			return nil, "", fmt.Errorf("Bundle source directory %s is not an OCI image layout. %w", source, err)
+		}
+		return getBundleFromLayout(source, func(name string) ([]byte, error) {
+			return ioutil.ReadFile(filepath.Join(source, filepath.FromSlash(name)))
+		})
+	}
+
+	files, err := readArchive(source)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// OCI image layout archives only contain the bundle in the layout
+	data, ok := files[archiveBundleFileName]
+	if !ok {
+		return getBundleFromLayout(source, func(name string) ([]byte, error) {
+			data, ok := files[name]
+			if !ok {
+				return nil, fmt.Errorf("File %s not found in archive", name)
+			}
+			return data, nil
+		})
+	}
+
+	bun, err := bundle.ParseReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to parse bundle file in archive: %s. %w", source, err)
+	}
+
+	bundleRef, err := getPorterArchiveReference(source, &bun, func(name string) ([]byte, error) {
+		data, ok := files[path.Join(archiveLayoutPath, name)]
+		if !ok {
+			return nil, fmt.Errorf("File %s not found in archive", path.Join(archiveLayoutPath, name))
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &bun, bundleRef, nil
+}
+
+// layoutBundle is a bundle index in an OCI image layout and the reference that it is annotated with
+type layoutBundle struct {
+	ref   string
+	index ocischemav1.Index
+}
+
+// getBundleFromLayout reads a bundle and resolves its reference from the index of an OCI image layout, the layout must contain a single bundle
+func getBundleFromLayout(source string, read layoutReader) (*bundle.Bundle, string, error) {
+	index, err := readLayoutIndex(source, read)
+	if err != nil {
+		return nil, "", err
+	}
+
+	bundles, err := getLayoutBundles(source, read, index)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(bundles) == 0 {
+		return nil, "", helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Unable to resolve the bundle reference, the OCI image layout index in %s does not contain a bundle with a reference annotation", source))
+	}
+
+	bun, err := readBundleFromIndex(read, bundles[0].index)
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to read bundle %s in %s: %w", bundles[0].ref, source, err)
+	}
+
+	return bun, bundles[0].ref, nil
+}
+
+// getPorterArchiveReference resolves the reference of the bundle in a porter archive. porter archives contain the bundle file and an OCI image layout of the images of the bundle,
+// if the layout contains the bundle index its reference is used, otherwise the reference is resolved from the reference of the invocation image in the layout index.
+// porter names the invocation image of a bundle after the bundle repository with the suffix -installer so the bundle reference is the invocation image reference without the suffix
+func getPorterArchiveReference(source string, bun *bundle.Bundle, read layoutReader) (string, error) {
+	index, err := readLayoutIndex(source, read)
+	if err != nil {
+		return "", err
+	}
+
+	bundles, err := getLayoutBundles(source, read, index)
+	if err != nil {
+		return "", err
+	}
+	if len(bundles) == 1 {
+		return bundles[0].ref, nil
+	}
+
+	layoutRefs := make(map[string]bool)
+	for _, descriptor := range index.Manifests {
+		if ref, ok := getDescriptorReference(descriptor); ok {
+			if named, err := reference.ParseNormalizedNamed(ref); err == nil {
+				layoutRefs[named.String()] = true
+			}
+		}
+	}
+
+	for _, invocationImage := range bun.InvocationImages {
+		if invocationImage.ImageType != "docker" {
+			continue
+		}
+
+		invocationImageRef, err := reference.ParseNormalizedNamed(invocationImage.Image)
+		if err != nil {
+			return "", helpers.NewError(helpers.ErrorKindInvalidBundle, fmt.Errorf("Cannot parse invocationImage reference: %s %w", invocationImage.Image, err))
+		}
+		if !layoutRefs[invocationImageRef.String()] {
+			continue
+		}
+
+		tagged, ok := invocationImageRef.(reference.Tagged)
+		if !ok || !strings.HasSuffix(invocationImageRef.Name(), porterInvocationImageSuffix) {
+			return "", helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Unable to resolve the bundle reference in porter archive %s, the invocation image %s is not a tagged image named with the suffix %s", source, invocationImage.Image, porterInvocationImageSuffix))
+		}
+
+		bundleName, err := reference.ParseNormalizedNamed(strings.TrimSuffix(invocationImageRef.Name(), porterInvocationImageSuffix))
+		if err != nil {
+			return "", helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Unable to resolve the bundle reference in porter archive %s from invocation image %s. %w", source, invocationImage.Image, err))
+		}
+		bundleRef, err := reference.WithTag(bundleName, tagged.Tag())
+		if err != nil {
+			return "", helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Unable to resolve the bundle reference in porter archive %s from invocation image %s. %w", source, invocationImage.Image, err))
+		}
+		return reference.FamiliarString(bundleRef), nil
+	}
+
+	return "", helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Unable to resolve the bundle reference, the OCI image layout index in porter archive %s does not contain the invocation image of the bundle", source))
+}
+
+func readLayoutIndex(source string, read layoutReader) (ocischemav1.Index, error) {
+	var index ocischemav1.Index
+	if err := readLayoutJSON(read, layoutIndexFileName, &index); err != nil {
+		return index, helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Unable to read OCI image layout index in %s: %w", source, err))
+	}
+	return index, nil
+}
+
+// getLayoutBundles returns the bundle indexes in an OCI image layout that are annotated with a full reference, an error is returned if the layout contains more than one bundle
+func getLayoutBundles(source string, read layoutReader, index ocischemav1.Index) ([]layoutBundle, error) {
+	var bundles []layoutBundle
+	for _, descriptor := range index.Manifests {
+		if descriptor.MediaType != ocischemav1.MediaTypeImageIndex {
+			continue
+		}
+		ref, ok := getDescriptorReference(descriptor)
+		if !ok {
+			continue
+		}
+		var bundleIndex ocischemav1.Index
+		if err := readLayoutBlob(read, descriptor, &bundleIndex); err != nil {
+			return nil, helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Unable to read index %s in %s: %w", ref, source, err))
+		}
+		// Multi-platform images are also stored as indexes, only bundle indexes have a config manifest
+		if _, ok := getBundleConfigManifest(bundleIndex); ok {
+			bundles = append(bundles, layoutBundle{ref: ref, index: bundleIndex})
+		}
+	}
+
+	if len(bundles) > 1 {
+		refs := make([]string, len(bundles))
+		for i, layoutBundle := range bundles {
+			refs[i] = layoutBundle.ref
+		}
+		return nil, helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Unable to resolve the bundle reference, the OCI image layout index in %s contains multiple bundles: %s", source, strings.Join(refs, ", ")))
+	}
+
+	return bundles, nil
+}
+
+// getDescriptorReference returns the full reference of a descriptor in an OCI image layout index, OCI image layouts may only record the tag so references that are not fully qualified are ignored
+func getDescriptorReference(descriptor ocischemav1.Descriptor) (string, bool) {
+	for _, annotation := range []string{containerdImageNameAnnotation, ocischemav1.AnnotationRefName} {
+		value, ok := descriptor.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		// a bare tag such as v1 would otherwise be normalised to an image in docker hub
+		if !strings.Contains(value, "/") {
+			continue
+		}
+		if _, err := reference.ParseNormalizedNamed(value); err == nil {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func getBundleConfigManifest(index ocischemav1.Index) (ocischemav1.Descriptor, bool) {
+	for _, descriptor := range index.Manifests {
+		if descriptor.Annotations[cnabManifestTypeAnnotation] == cnabManifestTypeConfig {
+			return descriptor, true
+		}
+	}
+	return ocischemav1.Descriptor{}, false
+}
+
+// readBundleFromIndex reads the bundle from the config blob of the config manifest of a bundle index
+func readBundleFromIndex(read layoutReader, index ocischemav1.Index) (*bundle.Bundle, error) {
+	configDescriptor, _ := getBundleConfigManifest(index)
+
+	var manifest ocischemav1.Manifest
+	if err := readLayoutBlob(read, configDescriptor, &manifest); err != nil {
+		return nil, err
+	}
+
+	data, err := readLayoutBlobData(read, manifest.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	bun, err := bundle.ParseReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse bundle: %w", err)
+	}
+
+	return &bun, nil
+}
+
+func readLayoutJSON(read layoutReader, name string, value interface{}) error {
+	data, err := read(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func readLayoutBlob(read layoutReader, descriptor ocischemav1.Descriptor, value interface{}) error {
+	data, err := readLayoutBlobData(read, descriptor)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func readLayoutBlobData(read layoutReader, descriptor ocischemav1.Descriptor) ([]byte, error) {
+	if err := descriptor.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid digest %s: %w", descriptor.Digest, err)
+	}
+	return read(path.Join("blobs", strings.Replace(descriptor.Digest.String(), ":", "/", 1)))
+}
+
+func isArchive(source string) bool {
+	lower := strings.ToLower(source)
+	return strings.HasSuffix(lower, ".tgz") || strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tar")
+}
+
+// readArchive reads the files in a tar or gzipped tar archive that are needed to read a bundle, image layers are skipped
+func readArchive(source string) (map[string][]byte, error) {
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open bundle archive: %s. %w", source, err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = bufio.NewReader(file)
+	if magic, err := reader.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decompress bundle archive: %s. %w", source, err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	files := make(map[string][]byte)
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read bundle archive: %s. %w", source, err)
+		}
+		if header.Typeflag != tar.TypeReg || header.Size > maxArchiveFileSize {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read %s from bundle archive: %s. %w", header.Name, source, err)
+		}
+		// porter archives may contain a top level directory named after the bundle
+		files[path.Clean(strings.TrimPrefix(header.Name, "./"))] = data
+	}
+
+	return normaliseArchiveRoot(files), nil
+}
+
+// normaliseArchiveRoot removes a single top level directory from the file names in an archive so that the bundle and layout are at the root
+func normaliseArchiveRoot(files map[string][]byte) map[string][]byte {
+	if _, ok := files[archiveBundleFileName]; ok {
+		return files
+	}
+	if _, ok := files[ocischemav1.ImageLayoutFile]; ok {
+		return files
+	}
+
+	var root string
+	for name := range files {
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) != 2 || (root != "" && root != parts[0]) {
+			return files
+		}
+		root = parts[0]
+	}
+
+	normalised := make(map[string][]byte, len(files))
+	for name, data := range files {
+		normalised[strings.TrimPrefix(name, root+"/")] = data
+	}
+	return normalised
+}
@@ -0,0 +1,115 @@
+package common
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+// writeArchive writes the files in dir to a gzipped tar archive, the file names are prefixed with root if it is not empty
+func writeArchive(t *testing.T, dir string, root string) string {
+	file, err := ioutil.TempFile("", "bundle-*.tgz")
+	assert.NilError(t, err)
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relative, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := tarWriter.WriteHeader(&tar.Header{
+			Name:     path.Join(root, filepath.ToSlash(relative)),
+			Mode:     0600,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			return err
+		}
+		_, err = tarWriter.Write(data)
+		return err
+	})
+	assert.NilError(t, err)
+	assert.NilError(t, tarWriter.Close())
+	assert.NilError(t, gzipWriter.Close())
+
+	return file.Name()
+}
+
+func TestIsLocalBundleSource(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected bool
+	}{
+		{"testdata/layout", true},
+		{"testdata/porter-archive/bundle.json", false},
+		{"testdata/missing.tgz", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, IsLocalBundleSource(test.source), test.source)
+	}
+}
+
+func TestGetBundleFromLocalSource(t *testing.T) {
+	layoutArchive := writeArchive(t, "testdata/layout", "")
+	defer os.Remove(layoutArchive)
+	porterArchive := writeArchive(t, "testdata/porter-archive", "")
+	defer os.Remove(porterArchive)
+	porterArchiveWithRoot := writeArchive(t, "testdata/porter-archive", "test-bundle")
+	defer os.Remove(porterArchiveWithRoot)
+
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"layout directory", "testdata/layout"},
+		{"layout archive", layoutArchive},
+		{"porter archive", porterArchive},
+		{"porter archive with a top level directory", porterArchiveWithRoot},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Assert(t, IsLocalBundleSource(test.source))
+
+			bun, bundleRef, err := GetBundleFromLocalSource(test.source)
+			assert.NilError(t, err)
+			assert.Equal(t, "test-bundle", bun.Name)
+			assert.Equal(t, "example.azurecr.io/test-bundle:v1", bundleRef)
+		})
+	}
+}
+
+func TestGetBundleFromLocalSourceErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		source        string
+		expectedError string
+	}{
+		{"layout without a full reference", "testdata/layout-no-reference", "does not contain a bundle with a reference annotation"},
+		{"layout with several bundles", "testdata/layout-multiple-bundles", "contains multiple bundles: example.azurecr.io/test-bundle:v1, example.azurecr.io/other-bundle:v2"},
+		{"directory that is not a layout", "testdata/porter-archive", "is not an OCI image layout"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := GetBundleFromLocalSource(test.source)
+			assert.ErrorContains(t, err, test.expectedError)
+		})
+	}
+}
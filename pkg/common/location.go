@@ -0,0 +1,167 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const (
+	// LocationSourceDefault specifies that the built in locations for the cloud environment are allowed
+	LocationSourceDefault = "default"
+	// LocationSourceNone specifies that locations are not restricted in generated templates
+	LocationSourceNone = "none"
+	// LocationsEnvVarName is the environment variable that contains the source of the allowed locations
+	LocationsEnvVarName = "CNAB_ARM_LOCATIONS"
+	// locationResourceType is the resource type used to select locations from az provider show output
+	locationResourceType = "containerGroups"
+)
+
+// LocationProvider provides the locations that resources in generated templates are allowed to be created in
+type LocationProvider interface {
+	// GetLocations returns the allowed locations for the cloud environment, an empty list means that locations are not restricted
+	GetLocations(cloud *CloudEnvironment) []string
+}
+
+type defaultLocationProvider struct{}
+
+func (defaultLocationProvider) GetLocations(cloud *CloudEnvironment) []string {
+	if cloud == nil {
+		return nil
+	}
+	return cloud.Locations
+}
+
+type noLocationProvider struct{}
+
+func (noLocationProvider) GetLocations(cloud *CloudEnvironment) []string {
+	return nil
+}
+
+type fileLocationProvider struct {
+	// locations is keyed by the lower case name of the cloud environment
+	locations map[string][]string
+}
+
+func (provider fileLocationProvider) GetLocations(cloud *CloudEnvironment) []string {
+	if cloud == nil {
+		return nil
+	}
+	if locations, exists := provider.locations[strings.ToLower(cloud.Name)]; exists {
+		return locations
+	}
+	return cloud.Locations
+}
+
+var locationProvider LocationProvider = defaultLocationProvider{}
+
+// NewLocationProvider creates a LocationProvider from a source, the source is either default, none or the path to a JSON file.
+// The file contains either an array of location names or the output of az provider show for Microsoft.ContainerInstance, which
+// are the locations for the cloud environment cloudName, or an object keyed by cloud environment name containing either of these.
+// Cloud environments that are not in the file use their built in locations
+func NewLocationProvider(source string, cloudName string) (LocationProvider, error) {
+	switch strings.ToLower(source) {
+	case "", LocationSourceDefault:
+		return defaultLocationProvider{}, nil
+	case LocationSourceNone:
+		return noLocationProvider{}, nil
+	}
+
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read locations file %s: %w", source, err)
+	}
+
+	cloudLocations, err := parseCloudLocations(data, cloudName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse locations file %s: %w", source, err)
+	}
+
+	if len(cloudLocations) == 0 {
+		return nil, fmt.Errorf("Locations file %s does not contain any locations", source)
+	}
+
+	for name, locations := range cloudLocations {
+		if len(locations) == 0 {
+			return nil, fmt.Errorf("Locations file %s does not contain any locations for cloud environment %s", source, name)
+		}
+	}
+
+	provider := fileLocationProvider{locations: make(map[string][]string)}
+	for name, locations := range cloudLocations {
+		provider.locations[strings.ToLower(name)] = locations
+	}
+	return provider, nil
+}
+
+// SetLocationProvider sets the LocationProvider used to get allowed locations, this should be called before any templates are generated
+func SetLocationProvider(provider LocationProvider) {
+	locationProvider = provider
+}
+
+// GetAllowedLocations returns the locations that resources in generated templates are allowed to be created in for the cloud environment
+func GetAllowedLocations(cloud *CloudEnvironment) []string {
+	return locationProvider.GetLocations(cloud)
+}
+
+// parseCloudLocations returns the locations in data keyed by cloud environment name, locations that are not keyed by cloud environment are returned for cloudName
+func parseCloudLocations(data []byte, cloudName string) (map[string][]string, error) {
+	var keyed map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keyed); err != nil {
+		// not an object so this is an array of locations
+		keyed = nil
+	}
+	if _, isProvider := keyed["resourceTypes"]; keyed == nil || isProvider {
+		keyed = map[string]json.RawMessage{cloudName: data}
+	}
+
+	cloudLocations := make(map[string][]string)
+	for name, value := range keyed {
+		cloud, err := GetCloudEnvironment(name)
+		if err != nil {
+			return nil, err
+		}
+		locations, err := parseLocations(value)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse locations for cloud environment %s: %w", cloud.Name, err)
+		}
+		cloudLocations[cloud.Name] = locations
+	}
+	return cloudLocations, nil
+}
+
+func parseLocations(data []byte) ([]string, error) {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		var provider struct {
+			ResourceTypes []struct {
+				ResourceType string   `json:"resourceType"`
+				Locations    []string `json:"locations"`
+			} `json:"resourceTypes"`
+		}
+		if err := json.Unmarshal(data, &provider); err != nil {
+			return nil, err
+		}
+		for _, resourceType := range provider.ResourceTypes {
+			if strings.EqualFold(resourceType.ResourceType, locationResourceType) {
+				names = resourceType.Locations
+				break
+			}
+		}
+	}
+
+	// az provider show returns display names such as "East US 2", templates require names such as eastus2
+	seen := make(map[string]bool)
+	var locations []string
+	for _, name := range names {
+		location := strings.ToLower(strings.ReplaceAll(name, " ", ""))
+		if len(location) > 0 && !seen[location] {
+			seen[location] = true
+			locations = append(locations, location)
+		}
+	}
+	sort.Strings(locations)
+	return locations, nil
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+const providerShowLocations = `{
+	"namespace": "Microsoft.ContainerInstance",
+	"resourceTypes": [
+		{
+			"resourceType": "operations",
+			"locations": []
+		},
+		{
+			"resourceType": "containerGroups",
+			"locations": ["West US", "East US 2", "North Europe", "East US 2"]
+		}
+	]
+}`
+
+func TestParseLocations(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected []string
+	}{
+		{"array", `["westus", "East US", "eastus", ""]`, []string{"eastus", "westus"}},
+		{"provider show", providerShowLocations, []string{"eastus2", "northeurope", "westus"}},
+		{"provider show without container groups", `{"resourceTypes":[{"resourceType":"operations","locations":["West US"]}]}`, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			locations, err := parseLocations([]byte(test.data))
+			assert.NilError(t, err)
+			assert.DeepEqual(t, test.expected, locations)
+		})
+	}
+
+	_, err := parseLocations([]byte(`"westus"`))
+	assert.ErrorContains(t, err, "cannot unmarshal")
+}
+
+func TestParseCloudLocations(t *testing.T) {
+	locations, err := parseCloudLocations([]byte(`["westus"]`), AzureUSGovernmentName)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, map[string][]string{AzureUSGovernmentName: {"westus"}}, locations)
+
+	locations, err = parseCloudLocations([]byte(providerShowLocations), "")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, map[string][]string{AzureCloudName: {"eastus2", "northeurope", "westus"}}, locations)
+
+	keyed := `{"azurecloud": ["West US"], "AzureUSGovernment": ` + `{"resourceTypes":[{"resourceType":"containerGroups","locations":["USGov Virginia"]}]}}`
+	locations, err = parseCloudLocations([]byte(keyed), AzureChinaCloudName)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, map[string][]string{AzureCloudName: {"westus"}, AzureUSGovernmentName: {"usgovvirginia"}}, locations)
+
+	_, err = parseCloudLocations([]byte(`{"AzureGermanCloud": ["germanycentral"]}`), AzureCloudName)
+	assert.ErrorContains(t, err, "Cloud environment AzureGermanCloud does not exist")
+}
+
+func TestNewLocationProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "locations")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name string, data string) string {
+		path := filepath.Join(dir, name)
+		assert.NilError(t, ioutil.WriteFile(path, []byte(data), 0644))
+		return path
+	}
+
+	publicCloud, err := GetCloudEnvironment(AzureCloudName)
+	assert.NilError(t, err)
+	govCloud, err := GetCloudEnvironment(AzureUSGovernmentName)
+	assert.NilError(t, err)
+
+	provider, err := NewLocationProvider(LocationSourceDefault, AzureCloudName)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, govCloud.Locations, provider.GetLocations(govCloud))
+
+	provider, err = NewLocationProvider(LocationSourceNone, AzureCloudName)
+	assert.NilError(t, err)
+	assert.Assert(t, provider.GetLocations(govCloud) == nil)
+
+	provider, err = NewLocationProvider(writeFile("array.json", `["westus"]`), AzureCloudName)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"westus"}, provider.GetLocations(publicCloud))
+	assert.DeepEqual(t, govCloud.Locations, provider.GetLocations(govCloud))
+
+	provider, err = NewLocationProvider(writeFile("keyed.json", `{"AzureUSGovernment": ["usgovtexas"]}`), AzureCloudName)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, publicCloud.Locations, provider.GetLocations(publicCloud))
+	assert.DeepEqual(t, []string{"usgovtexas"}, provider.GetLocations(govCloud))
+
+	_, err = NewLocationProvider(writeFile("empty.json", `[]`), AzureCloudName)
+	assert.ErrorContains(t, err, "does not contain any locations for cloud environment AzureCloud")
+
+	_, err = NewLocationProvider(writeFile("empty-object.json", `{}`), AzureCloudName)
+	assert.ErrorContains(t, err, "does not contain any locations")
+
+	_, err = NewLocationProvider(filepath.Join(dir, "missing.json"), AzureCloudName)
+	assert.ErrorContains(t, err, "Failed to read locations file")
+}
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// LogFormatText specifies that log entries are written as text
+	LogFormatText = "text"
+	// LogFormatJSON specifies that log entries are written as JSON objects
+	LogFormatJSON = "json"
+	// LogFormatEnvVarName is the environment variable that contains the log format
+	LogFormatEnvVarName = "CNAB_ARM_LOG_FORMAT"
+)
+
+// LoggerContextKey is the type of the key of the logger in the request context
+type LoggerContextKey string
+
+// LoggerContext is the key of the logger in the request context
+const LoggerContext LoggerContextKey = "logger"
+
+// SetLogFormat sets the format that log entries are written in, either text or json
+func SetLogFormat(format string) error {
+	switch strings.ToLower(format) {
+	case LogFormatText:
+		log.SetFormatter(&log.TextFormatter{})
+	case LogFormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("Invalid log format %s, the format must be either %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+	return nil
+}
+
+// requestLogger is the logger carried in the request context, fields added by handlers are included in every later log entry for the request including the entry logged when the request completes
+type requestLogger struct {
+	entry *log.Entry
+}
+
+// GetLogger returns the logger carried in ctx, if ctx does not carry a logger the standard logger is returned
+func GetLogger(ctx context.Context) *log.Entry {
+	if logger, ok := ctx.Value(LoggerContext).(*requestLogger); ok {
+		return logger.entry
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// AddLogFields adds fields to the logger carried in ctx
+func AddLogFields(ctx context.Context, fields log.Fields) {
+	if logger, ok := ctx.Value(LoggerContext).(*requestLogger); ok {
+		logger.entry = logger.entry.WithFields(fields)
+	}
+}
+
+// RequestLogger is HTTP middleware that carries a logger with the request ID in the request context and logs each request when it completes,
+// any credentials are removed from the logged request URI
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		logger := &requestLogger{
+			entry: GetLogger(r.Context()).WithField("request_id", middleware.GetReqID(r.Context())),
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), LoggerContext, logger)))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		logger.entry.WithFields(log.Fields{
+			"method":   r.Method,
+			"uri":      RedactURI(r.RequestURI),
+			"status":   status,
+			"bytes":    ww.BytesWritten(),
+			"duration": time.Since(start).String(),
+		}).Info("Request completed")
+	})
+}
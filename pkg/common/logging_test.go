@@ -0,0 +1,57 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/middleware"
+	log "github.com/sirupsen/logrus"
+	"gotest.tools/assert"
+)
+
+func TestSetLogFormat(t *testing.T) {
+	defer log.SetFormatter(&log.TextFormatter{})
+
+	assert.NilError(t, SetLogFormat(LogFormatJSON))
+	assert.NilError(t, SetLogFormat("TEXT"))
+	assert.ErrorContains(t, SetLogFormat("xml"), "Invalid log format xml")
+}
+
+func TestRequestLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := log.New()
+	logger.Out = &out
+
+	handler := middleware.RequestID(RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddLogFields(r.Context(), log.Fields{"bundle": "example.com/bundle"})
+		GetLogger(r.Context()).Infof("Handling request")
+		w.WriteHeader(http.StatusNotFound)
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/generate/template?tag=example.com/bundle:v1&token=secret", nil)
+	r.Header.Set(middleware.RequestIDHeader, "test-request")
+	// The logger that the request logger adds fields to is taken from the request context
+	r = r.WithContext(context.WithValue(r.Context(), LoggerContext, &requestLogger{entry: log.NewEntry(logger)}))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 2, len(lines), out.String())
+	assert.Assert(t, strings.Contains(lines[0], `msg="Handling request"`), lines[0])
+	for _, line := range lines {
+		assert.Assert(t, strings.Contains(line, "request_id=test-request"), line)
+		assert.Assert(t, strings.Contains(line, "bundle=example.com/bundle"), line)
+	}
+	assert.Assert(t, strings.Contains(lines[1], `msg="Request completed"`), lines[1])
+	assert.Assert(t, strings.Contains(lines[1], "status=404"), lines[1])
+	assert.Assert(t, !strings.Contains(lines[1], "secret"), lines[1])
+}
+
+func TestGetLoggerWithoutRequestLogger(t *testing.T) {
+	assert.Assert(t, GetLogger(context.Background()) != nil)
+	// Fields cannot be added without a request logger
+	AddLogFields(context.Background(), log.Fields{"bundle": "example.com/bundle"})
+}
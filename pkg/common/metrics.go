@@ -0,0 +1,127 @@
+package common
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
+)
+
+const (
+	// MetricsRegistriesEnvVarName is the environment variable that contains a comma separated list of the registry hosts that bundle pull metrics are recorded for
+	MetricsRegistriesEnvVarName = "CNAB_ARM_METRICS_REGISTRIES"
+	// unmatchedRoute is the route label of requests that did not match a route, the request path is not used so that the number of labels is bounded
+	unmatchedRoute = "unmatched"
+	// otherRegistry is the registry label of bundle pulls from registries that are not known, the registry host is not used so that the number of labels is bounded
+	otherRegistry = "other"
+)
+
+// DefaultMetricsRegistries are the public registry hosts that bundle pull metrics are always recorded for
+var DefaultMetricsRegistries = []string{"docker.io", "ghcr.io", "mcr.microsoft.com", "quay.io"}
+
+// metricsRegistries is the set of registry hosts that are used as the registry label of bundle pull metrics
+var metricsRegistries = newMetricsRegistries(nil)
+
+// durationBuckets are the histogram buckets in seconds for requests and bundle pulls, requests that pull a bundle can take up to the request timeout
+var durationBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cnabarm",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "The number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cnabarm",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "The time taken to handle HTTP requests by route, method and status code.",
+		Buckets:   durationBuckets,
+	}, []string{"route", "method", "status"})
+	bundlePullDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cnabarm",
+		Subsystem: "bundle",
+		Name:      "pull_duration_seconds",
+		Help:      "The time taken to pull bundles from the registry by registry host, including pulls that failed.",
+		Buckets:   durationBuckets,
+	}, []string{"registry"})
+	bundlePullFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cnabarm",
+		Subsystem: "bundle",
+		Name:      "pull_failures_total",
+		Help:      "The number of bundle pulls that failed by registry host and kind of error.",
+	}, []string{"registry", "kind"})
+	generatorFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cnabarm",
+		Subsystem: "generator",
+		Name:      "failures_total",
+		Help:      "The number of requests that failed to generate output by generator and kind of error.",
+	}, []string{"generator", "kind"})
+)
+
+// Metrics is HTTP middleware that records the number and duration of requests by route, method and status code
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil && len(routeContext.RoutePattern()) > 0 {
+			route = routeContext.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		labels := []string{route, r.Method, strconv.Itoa(status)}
+		httpRequests.WithLabelValues(labels...).Inc()
+		httpRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
+
+// RecordGeneratorFailure records that a generator failed, generator is the name of the output that was being generated
+func RecordGeneratorFailure(generator string, err error) {
+	generatorFailures.WithLabelValues(generator, helpers.KindOf(err).String()).Inc()
+}
+
+// SetMetricsRegistries sets the registry hosts that bundle pull metrics are recorded for in addition to the public registries, pulls from any other registry are recorded with the registry label other
+func SetMetricsRegistries(registries []string) {
+	metricsRegistries = newMetricsRegistries(registries)
+}
+
+func newMetricsRegistries(registries []string) map[string]bool {
+	known := make(map[string]bool, len(DefaultMetricsRegistries)+len(registries))
+	for _, registry := range append(DefaultMetricsRegistries, registries...) {
+		if registry = strings.ToLower(strings.TrimSpace(registry)); len(registry) > 0 {
+			known[registry] = true
+		}
+	}
+	return known
+}
+
+// registryLabel returns the registry label of a bundle pull, the registry host is only used if it is one of the known registries as the host is taken from the request
+func registryLabel(registry string) string {
+	registry = strings.ToLower(registry)
+	if metricsRegistries[registry] {
+		return registry
+	}
+	return otherRegistry
+}
+
+// recordBundlePull records the duration of a bundle pull from a registry and, if it failed, the kind of error
+func recordBundlePull(registry string, start time.Time, err error) {
+	label := registryLabel(registry)
+	bundlePullDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	if err != nil {
+		bundlePullFailures.WithLabelValues(label, helpers.KindOf(err).String()).Inc()
+	}
+}
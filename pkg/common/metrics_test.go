@@ -0,0 +1,47 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
+	"gotest.tools/assert"
+)
+
+func TestRegistryLabel(t *testing.T) {
+	defer SetMetricsRegistries(nil)
+	SetMetricsRegistries([]string{" MyRegistry.azurecr.io ", ""})
+
+	tests := []struct {
+		registry string
+		expected string
+	}{
+		{"docker.io", "docker.io"},
+		{"mcr.microsoft.com", "mcr.microsoft.com"},
+		{"myregistry.azurecr.io", "myregistry.azurecr.io"},
+		{"MYREGISTRY.AZURECR.IO", "myregistry.azurecr.io"},
+		{"otherregistry.azurecr.io", otherRegistry},
+		{"localhost:5000", otherRegistry},
+		{"", otherRegistry},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, registryLabel(test.registry), test.registry)
+	}
+}
+
+func TestRecordBundlePullBoundsRegistryLabel(t *testing.T) {
+	defer SetMetricsRegistries(nil)
+	SetMetricsRegistries(nil)
+	kind := helpers.ErrorKindRegistryUnavailable.String()
+	failures := testutil.ToFloat64(bundlePullFailures.WithLabelValues(otherRegistry, kind))
+
+	// Hosts taken from requests, including hosts that cannot be resolved, are recorded as other
+	for _, registry := range []string{"unresolvable.invalid", "10.0.0.1:5000"} {
+		recordBundlePull(registry, time.Now(), helpers.NewError(helpers.ErrorKindRegistryUnavailable, errors.New("Unable to reach registry")))
+		assert.Equal(t, 0.0, testutil.ToFloat64(bundlePullFailures.WithLabelValues(registry, kind)), registry)
+	}
+	assert.Equal(t, failures+2, testutil.ToFloat64(bundlePullFailures.WithLabelValues(otherRegistry, kind)))
+}
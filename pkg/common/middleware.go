@@ -4,14 +4,21 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-
-	log "github.com/sirupsen/logrus"
+	"net/url"
+	"regexp"
+	"strings"
 )
 
 type OriginalRequestURIContextKey string
 
 const RequestURIContext OriginalRequestURIContextKey = "OriginalRequestURI"
 
+// redactedValue replaces credentials in logged URIs
+const redactedValue = "REDACTED"
+
+// sensitiveQueryParamPattern matches the names of query parameters that may contain credentials
+var sensitiveQueryParamPattern = regexp.MustCompile(`(?i)token|password|secret|sig|credential|auth`)
+
 // HTTP middleware setting original request URL on context
 func SetOriginalRequestURI(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -21,8 +28,37 @@ func SetOriginalRequestURI(next http.Handler) http.Handler {
 		} else {
 			uri = fmt.Sprintf("https://%s%s", r.Host, r.RequestURI)
 		}
-		log.Infof("Request URI: %s", uri)
+		GetLogger(r.Context()).Infof("Request URI: %s", RedactURI(uri))
 		ctx := context.WithValue(r.Context(), RequestURIContext, uri)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// RedactURI removes any password in the user info and the values of any query parameters that may contain credentials from a URI so that it can be logged
+func RedactURI(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return redactedValue
+	}
+
+	if parsed.User != nil {
+		if _, hasPassword := parsed.User.Password(); hasPassword {
+			parsed.User = url.UserPassword(parsed.User.Username(), redactedValue)
+		}
+	}
+
+	// The query is redacted in place so that the order of the parameters is unchanged
+	params := strings.Split(parsed.RawQuery, "&")
+	for i, param := range params {
+		name := strings.SplitN(param, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if sensitiveQueryParamPattern.MatchString(name) {
+			params[i] = fmt.Sprintf("%s=%s", strings.SplitN(param, "=", 2)[0], redactedValue)
+		}
+	}
+	parsed.RawQuery = strings.Join(params, "&")
+
+	return parsed.String()
+}
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
+)
+
+const (
+	// DefaultCloudProfileName is the name of the cloud profile used when no profile is specified
+	DefaultCloudProfileName = "default"
+	// DogfoodCloudProfileName is the name of the built in cloud profile for the dogfood environment
+	DogfoodCloudProfileName = "dogfood"
+	// CloudProfilesFileEnvVarName is the environment variable that contains the path to a file with additional cloud profiles
+	CloudProfilesFileEnvVarName = "CNAB_ARM_CLOUD_PROFILES_FILE"
+)
+
+// CloudProfile defines the resource provider used to install bundles via Arc
+type CloudProfile struct {
+	Name              string   `json:"name"`
+	ProviderNamespace string   `json:"providerNamespace"`
+	ResourceType      string   `json:"resourceType"`
+	APIVersion        string   `json:"apiVersion"`
+	Locations         []string `json:"locations"`
+	// PortalURL is optional, if it is set it overrides the portal of the cloud environment
+	PortalURL string `json:"portalUrl,omitempty"`
+}
+
+var cloudProfiles = map[string]CloudProfile{
+	DefaultCloudProfileName: {
+		Name:              DefaultCloudProfileName,
+		ProviderNamespace: "Microsoft.Contoso",
+		ResourceType:      "installations",
+		APIVersion:        "2021-04-01-preview",
+		Locations:         []string{"eastus2euap"},
+	},
+	DogfoodCloudProfileName: {
+		Name:              DogfoodCloudProfileName,
+		ProviderNamespace: "Microsoft.CNAB",
+		ResourceType:      "installations",
+		APIVersion:        "2021-02-12-preview",
+		Locations:         []string{"westus"},
+		PortalURL:         "https://df.onecloud.azure-test.net",
+	},
+}
+
+// FullResourceType returns the resource type of the installation resource including the provider namespace
+func (profile CloudProfile) FullResourceType() string {
+	return fmt.Sprintf("%s/%s", profile.ProviderNamespace, profile.ResourceType)
+}
+
+// Validate checks that the required properties of a cloud profile are set
+func (profile CloudProfile) Validate() error {
+	var missing []string
+	if profile.Name == "" {
+		missing = append(missing, "name")
+	}
+	if profile.ProviderNamespace == "" {
+		missing = append(missing, "providerNamespace")
+	}
+	if profile.ResourceType == "" {
+		missing = append(missing, "resourceType")
+	}
+	if profile.APIVersion == "" {
+		missing = append(missing, "apiVersion")
+	}
+	if len(profile.Locations) == 0 {
+		missing = append(missing, "locations")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Cloud profile %s is missing required properties: %s", profile.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// LoadCloudProfiles loads cloud profiles from a JSON file containing an array of profiles, a profile with the same name as an existing profile replaces it.
+// This should be called before any templates are generated
+func LoadCloudProfiles(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("Failed to read cloud profiles file %s: %w", fileName, err)
+	}
+
+	var profiles []CloudProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return fmt.Errorf("Failed to parse cloud profiles file %s: %w", fileName, err)
+	}
+
+	for _, profile := range profiles {
+		if err := profile.Validate(); err != nil {
+			return fmt.Errorf("Invalid profile in cloud profiles file %s: %w", fileName, err)
+		}
+		cloudProfiles[strings.ToLower(profile.Name)] = profile
+	}
+
+	return nil
+}
+
+// GetCloudProfile returns the cloud profile with the given name, if name is empty the default profile is returned
+func GetCloudProfile(name string) (*CloudProfile, error) {
+	if name == "" {
+		name = DefaultCloudProfileName
+	}
+
+	profile, ok := cloudProfiles[strings.ToLower(name)]
+	if !ok {
+		return nil, helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Cloud profile %s does not exist, available profiles are %s", name, strings.Join(GetCloudProfileNames(), ", ")))
+	}
+
+	return &profile, nil
+}
+
+// GetCloudProfileNames returns the sorted names of the available cloud profiles
+func GetCloudProfileNames() []string {
+	var names []string
+	for name := range cloudProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestCloudProfileValidate(t *testing.T) {
+	valid := CloudProfile{
+		Name:              "test",
+		ProviderNamespace: "Microsoft.Test",
+		ResourceType:      "installations",
+		APIVersion:        "2021-01-01",
+		Locations:         []string{"westus"},
+	}
+	noLocations := valid
+	noLocations.Locations = []string{}
+
+	tests := []struct {
+		name     string
+		profile  CloudProfile
+		expected string
+	}{
+		{"valid", valid, ""},
+		{"no locations", noLocations, "Cloud profile test is missing required properties: locations"},
+		{"empty", CloudProfile{}, "Cloud profile  is missing required properties: name, providerNamespace, resourceType, apiVersion, locations"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.profile.Validate()
+			if test.expected == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, test.expected)
+		})
+	}
+
+	for name, profile := range cloudProfiles {
+		assert.NilError(t, profile.Validate(), name)
+	}
+}
+
+func TestLoadCloudProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	saved := make(map[string]CloudProfile, len(cloudProfiles))
+	for name, profile := range cloudProfiles {
+		saved[name] = profile
+	}
+	defer func() { cloudProfiles = saved }()
+
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{"valid", `[{"name": "Test", "providerNamespace": "Microsoft.Test", "resourceType": "installations", "apiVersion": "2021-01-01", "locations": ["westus"]}]`, ""},
+		{"no locations", `[{"name": "NoLocations", "providerNamespace": "Microsoft.Test", "resourceType": "installations", "apiVersion": "2021-01-01", "locations": []}]`, "Cloud profile NoLocations is missing required properties: locations"},
+		{"invalid json", `{`, "Failed to parse cloud profiles file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(dir, "profiles.json")
+			assert.NilError(t, ioutil.WriteFile(fileName, []byte(test.data), 0644))
+
+			err := LoadCloudProfiles(fileName)
+			if test.expected == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, test.expected)
+		})
+	}
+
+	profile, err := GetCloudProfile("test")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"westus"}, profile.Locations)
+
+	_, err = GetCloudProfile("nolocations")
+	assert.ErrorContains(t, err, "Cloud profile nolocations does not exist")
+}
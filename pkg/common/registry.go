@@ -0,0 +1,160 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+)
+
+const (
+	// RegistryConfigEnvVarName is the environment variable that contains the path to a docker config file with registry credentials
+	RegistryConfigEnvVarName = "CNAB_ARM_REGISTRY_CONFIG"
+	// RegistryCredentialsEnvVarPrefix is the prefix of the environment variables that contain the credentials for a registry,
+	// the variables are named CNAB_ARM_REGISTRY_<REGISTRY>_USERNAME, CNAB_ARM_REGISTRY_<REGISTRY>_PASSWORD and CNAB_ARM_REGISTRY_<REGISTRY>_REFRESH_TOKEN
+	// where <REGISTRY> is the registry host name in upper case with any character that is not a letter or a digit replaced by an underscore
+	RegistryCredentialsEnvVarPrefix = "CNAB_ARM_REGISTRY_"
+	// CanaryRegistryEnvVarName is the environment variable that contains the host name of a registry that must be reachable for the listener to be ready
+	CanaryRegistryEnvVarName = "CNAB_ARM_CANARY_REGISTRY"
+	// dockerHubRegistry is the registry name of docker hub in bundle references
+	dockerHubRegistry = "docker.io"
+	// dockerHubConfigKey is the key of the docker hub credentials in a docker config file
+	dockerHubConfigKey = "https://index.docker.io/v1/"
+	// dockerHubRegistryHost is the host name of the docker hub registry API
+	dockerHubRegistryHost = "registry-1.docker.io"
+)
+
+var registryEnvVarInvalidChars = regexp.MustCompile(`[^A-Z0-9]`)
+
+var registryConfigFileName string
+
+// SetRegistryConfigFile sets the docker config file that registry credentials are read from, the file is read each time a bundle is pulled so that updated credentials are used.
+// If the file name is empty the default docker config file is used
+func SetRegistryConfigFile(fileName string) error {
+	if len(fileName) > 0 {
+		if _, err := loadRegistryConfigFile(fileName); err != nil {
+			return err
+		}
+	}
+	registryConfigFileName = fileName
+	return nil
+}
+
+// getRegistryConfig returns the docker config used to authenticate to a registry.
+// Credentials are taken from registryRefreshToken if it is set, otherwise from the environment variables for the registry, otherwise from the registry config file
+func getRegistryConfig(registry string, registryRefreshToken string) (*configfile.ConfigFile, error) {
+	var cfg *configfile.ConfigFile
+	if len(registryConfigFileName) > 0 {
+		var err error
+		if cfg, err = loadRegistryConfigFile(registryConfigFileName); err != nil {
+			return nil, err
+		}
+	} else {
+		cfg = config.LoadDefaultConfigFile(os.Stderr)
+	}
+
+	authConfig, ok := getRegistryAuthConfig(registry, registryRefreshToken)
+	if !ok {
+		return cfg, nil
+	}
+
+	configKey := registry
+	if registry == dockerHubRegistry {
+		configKey = dockerHubConfigKey
+	}
+	authConfig.ServerAddress = configKey
+
+	// Credential helpers take precedence over the credentials in the config file so they are removed
+	cfg.CredentialsStore = ""
+	delete(cfg.CredentialHelpers, configKey)
+	if cfg.AuthConfigs == nil {
+		cfg.AuthConfigs = make(map[string]types.AuthConfig)
+	}
+	cfg.AuthConfigs[configKey] = authConfig
+
+	return cfg, nil
+}
+
+// getRegistryAuthConfig returns the credentials for a registry from registryRefreshToken or the environment variables for the registry.
+// A refresh token is stored as the identity token of the registry, which is exchanged for an access token using the OAuth2 refresh token grant of the registry token service, such as an Azure Container Registry refresh token
+func getRegistryAuthConfig(registry string, registryRefreshToken string) (types.AuthConfig, bool) {
+	if len(registryRefreshToken) > 0 {
+		return types.AuthConfig{
+			IdentityToken: registryRefreshToken,
+		}, true
+	}
+
+	prefix := fmt.Sprintf("%s%s_", RegistryCredentialsEnvVarPrefix, registryEnvVarInvalidChars.ReplaceAllString(strings.ToUpper(registry), "_"))
+	if token := os.Getenv(prefix + "REFRESH_TOKEN"); len(token) > 0 {
+		return types.AuthConfig{
+			IdentityToken: token,
+		}, true
+	}
+
+	username, password := os.Getenv(prefix+"USERNAME"), os.Getenv(prefix+"PASSWORD")
+	if len(username) > 0 && len(password) > 0 {
+		return types.AuthConfig{
+			Username: username,
+			Password: password,
+		}, true
+	}
+
+	return types.AuthConfig{}, false
+}
+
+// CheckRegistryConfig checks that the docker config file that registry credentials are read from can be loaded
+func CheckRegistryConfig() error {
+	if len(registryConfigFileName) > 0 {
+		_, err := loadRegistryConfigFile(registryConfigFileName)
+		return err
+	}
+	if _, err := config.Load(config.Dir()); err != nil {
+		return fmt.Errorf("Unable to load docker config file in %s. %w", config.Dir(), err)
+	}
+	return nil
+}
+
+// CheckRegistryReachable checks that a registry responds to requests to the registry API, any response including an authentication challenge means that the registry is reachable
+func CheckRegistryReachable(ctx context.Context, registry string) error {
+	host := registry
+	if host == dockerHubRegistry {
+		host = dockerHubRegistryHost
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/v2/", host), nil)
+	if err != nil {
+		return fmt.Errorf("Invalid registry %s. %w", registry, err)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("Unable to reach registry %s. %w", registry, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("Registry %s returned status %s", registry, response.Status)
+	}
+	return nil
+}
+
+func loadRegistryConfigFile(fileName string) (*configfile.ConfigFile, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open registry config file: %s. %w", fileName, err)
+	}
+	defer file.Close()
+
+	cfg := configfile.New(fileName)
+	if err := cfg.LoadFromReader(file); err != nil {
+		return nil, fmt.Errorf("Unable to parse registry config file: %s. %w", fileName, err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"os"
+	"testing"
+
+	"github.com/docker/cli/cli/config/types"
+	"gotest.tools/assert"
+)
+
+// setEnv sets environment variables, the function returned restores their previous values
+func setEnv(values map[string]string) func() {
+	previous := make(map[string]*string, len(values))
+	for name, value := range values {
+		if current, ok := os.LookupEnv(name); ok {
+			previous[name] = &current
+		} else {
+			previous[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, value := range previous {
+			if value == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *value)
+			}
+		}
+	}
+}
+
+func TestGetRegistryAuthConfig(t *testing.T) {
+	defer setEnv(map[string]string{
+		"CNAB_ARM_REGISTRY_MYREGISTRY_AZURECR_IO_REFRESH_TOKEN": "env-refresh-token",
+		"CNAB_ARM_REGISTRY_LOCALHOST_5000_USERNAME":             "user",
+		"CNAB_ARM_REGISTRY_LOCALHOST_5000_PASSWORD":             "password",
+		"CNAB_ARM_REGISTRY_DOCKER_IO_USERNAME":                  "user-without-password",
+	})()
+
+	tests := []struct {
+		name                 string
+		registry             string
+		registryRefreshToken string
+		expected             types.AuthConfig
+		expectedOk           bool
+	}{
+		{"request refresh token", "myregistry.azurecr.io", "request-refresh-token", types.AuthConfig{IdentityToken: "request-refresh-token"}, true},
+		{"environment refresh token", "myregistry.azurecr.io", "", types.AuthConfig{IdentityToken: "env-refresh-token"}, true},
+		{"environment refresh token for a registry in lower case", "MyRegistry.azurecr.io", "", types.AuthConfig{IdentityToken: "env-refresh-token"}, true},
+		{"environment user name and password for a registry with a port", "localhost:5000", "", types.AuthConfig{Username: "user", Password: "password"}, true},
+		{"environment user name without a password", "docker.io", "", types.AuthConfig{}, false},
+		{"no credentials", "otherregistry.azurecr.io", "", types.AuthConfig{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authConfig, ok := getRegistryAuthConfig(test.registry, test.registryRefreshToken)
+			assert.Equal(t, test.expectedOk, ok)
+			assert.DeepEqual(t, test.expected, authConfig)
+		})
+	}
+}
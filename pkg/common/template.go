@@ -16,9 +16,9 @@ var CNABParam = []string{
 
 // EnvironmentVariableNames defines environment variables names
 type EnvironmentVariableNames struct {
-	CnabParameterPrefix                       string
-	CnabCredentialPrefix                      string
-	CnabCredentialFilePrefix                  string
+	CnabParameterSet                          string
+	CnabCredentialSet                         string
+	CnabCredentialFiles                       string
 	CnabAction                                string
 	CnabInstallationName                      string
 	CnabBundleName                            string
@@ -38,9 +38,9 @@ type EnvironmentVariableNames struct {
 // GetEnvironmentVariableNames returns environment variable names
 func GetEnvironmentVariableNames() EnvironmentVariableNames {
 	return EnvironmentVariableNames{
-		CnabParameterPrefix:                       "CNAB_PARAM_",
-		CnabCredentialPrefix:                      "CNAB_CRED_",
-		CnabCredentialFilePrefix:                  "CNAB_CRED_FILE_",
+		CnabParameterSet:                          "CNAB_PARAMETER_SET",
+		CnabCredentialSet:                         "CNAB_CREDENTIAL_SET",
+		CnabCredentialFiles:                       "CNAB_CREDENTIAL_FILES",
 		CnabAction:                                "CNAB_ACTION",
 		CnabInstallationName:                      "CNAB_INSTALLATION_NAME",
 		CnabBundleName:                            "CNAB_BUNDLE_NAME",
@@ -58,6 +58,13 @@ func GetEnvironmentVariableNames() EnvironmentVariableNames {
 	}
 }
 
+// The default tool versions are the versions that generated templates are tested with, the latest version of a tool is only used if the version is set to latest
+const (
+	DefaultPorterVersion      = "v0.28.1"
+	DefaultAzurePluginVersion = "v0.4.0"
+	DefaultAzureDriverVersion = "v0.0.1"
+)
+
 var ParameterDefaults = map[string]interface{}{
 	"location":                              "[resourceGroup().Location]",
 	"deployment_script_cleanup":             "Always",
@@ -68,7 +75,11 @@ var ParameterDefaults = map[string]interface{}{
 	"debug":                                 false,
 	"cnab_delete_outputs_from_fileshare":    true,
 	"msi_name":                              "cnabinstall",
-	"porter_version":                        "latest",
+	"porter_version":                        DefaultPorterVersion,
+	"azure_plugin_version":                  DefaultAzurePluginVersion,
+	"cnab_azure_driver_version":             DefaultAzureDriverVersion,
+	"keyvault_name":                         "[concat('cnabkv',uniqueString(resourceGroup().id))]",
+	"allow_bundle_change":                   false,
 }
 
 const AKSResourceParameterName = "aksClusterName"
@@ -2,8 +2,14 @@ package common
 
 import (
 	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
 )
 
+var checksumRegex = regexp.MustCompile("^[0-9a-fA-F]{64}$")
+
 // ValidateTimeout validates the timeout parameter
 func ValidateTimeout(timeout int) error {
 	minTimeout := 5
@@ -11,6 +17,41 @@ func ValidateTimeout(timeout int) error {
 	if timeout >= minTimeout && timeout <= maxTimeout {
 		return nil
 	}
-	return fmt.Errorf("Value %d for param timeout is less than min value %d or greater than max value %d", timeout, minTimeout, maxTimeout)
+	return helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Value %d for param timeout is less than min value %d or greater than max value %d", timeout, minTimeout, maxTimeout))
 
 }
+
+// ValidateFormat validates the output format parameter
+func ValidateFormat(format string) error {
+	switch format {
+	case "", OutputFormatJSON, OutputFormatBicep:
+		return nil
+	}
+	return helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Value %s for param format is not supported, supported values are %s and %s", format, OutputFormatJSON, OutputFormatBicep))
+}
+
+// ValidateMirrorURL validates that the tools mirror URL is either empty or an absolute http or https URL without a query string
+func ValidateMirrorURL(mirrorURL string) error {
+	if len(mirrorURL) == 0 {
+		return nil
+	}
+	parsedURL, err := url.Parse(mirrorURL)
+	if err != nil {
+		return helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Value %s for param mirror URL is not a valid URL: %w", mirrorURL, err))
+	}
+	if (parsedURL.Scheme != "https" && parsedURL.Scheme != "http") || len(parsedURL.Host) == 0 {
+		return helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Value %s for param mirror URL must be an absolute http or https URL", mirrorURL))
+	}
+	if len(parsedURL.RawQuery) > 0 {
+		return helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Value %s for param mirror URL must not contain a query string, SAS tokens should be provided using the tools_mirror_sas_token template parameter", mirrorURL))
+	}
+	return nil
+}
+
+// ValidateChecksum validates that a checksum is either empty or a hex encoded SHA-256 checksum
+func ValidateChecksum(checksum string) error {
+	if len(checksum) == 0 || checksumRegex.MatchString(checksum) {
+		return nil
+	}
+	return helpers.NewError(helpers.ErrorKindInvalidRequest, fmt.Errorf("Value %s is not a valid SHA-256 checksum", checksum))
+}
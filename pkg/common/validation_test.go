@@ -0,0 +1,42 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
+	"gotest.tools/assert"
+)
+
+func TestValidationErrorsAreInvalidRequests(t *testing.T) {
+	_, cloudErr := GetCloudEnvironment("AzureGermanCloud")
+	_, profileErr := GetCloudProfile("missing")
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"timeout", ValidateTimeout(1)},
+		{"format", ValidateFormat("yaml")},
+		{"mirror url scheme", ValidateMirrorURL("ftp://mirror.example.com")},
+		{"mirror url query", ValidateMirrorURL("https://mirror.example.com/tools?sig=secret")},
+		{"checksum", ValidateChecksum("abc")},
+		{"tool versions", ToolVersions{AzureDriverChecksum: "abc"}.Validate()},
+		{"cloud environment", cloudErr},
+		{"cloud profile", profileErr},
+	}
+
+	for _, test := range tests {
+		assert.Assert(t, test.err != nil, test.name)
+		assert.Equal(t, helpers.ErrorKindInvalidRequest, helpers.KindOf(test.err), test.name)
+	}
+}
+
+func TestValidationAcceptsValidValues(t *testing.T) {
+	assert.NilError(t, ValidateTimeout(15))
+	assert.NilError(t, ValidateFormat(""))
+	assert.NilError(t, ValidateFormat(OutputFormatBicep))
+	assert.NilError(t, ValidateMirrorURL(""))
+	assert.NilError(t, ValidateMirrorURL("https://mirror.example.com/tools"))
+	assert.NilError(t, ValidateChecksum(strings.Repeat("a", 64)))
+}
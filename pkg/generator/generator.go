@@ -14,12 +14,16 @@ import (
 	"get.porter.sh/porter/pkg/cnab/extensions"
 	"github.com/cnabio/cnab-go/bundle"
 	"github.com/cnabio/cnab-go/bundle/definition"
-	"github.com/containerd/containerd/log"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/common"
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/template"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/uidefinition"
+	log "github.com/sirupsen/logrus"
 )
 
+// getBundleDetails gets the bundle that a template is generated from, it is a variable so that tests can replace the registry
+var getBundleDetails = common.GetBundleDetails
+
 // GenerateNestedDeploymentOptions is the set of options for configuring GenerateNestedDeployment
 type GenerateNestedDeploymentOptions struct {
 	Uri string
@@ -29,14 +33,14 @@ type GenerateNestedDeploymentOptions struct {
 // GenerateNestedDeployment generates ARM deployment resource from bundle metadata
 func GenerateNestedDeployment(options GenerateNestedDeploymentOptions) error {
 
-	bundle, err := common.GetBundleFromTag(options.BundlePullOptions)
+	bundle, _, _, err := common.GetBundleFromTag(options.Options)
 	if err != nil {
 		return err
 	}
 
 	generatedDeployment := template.NewCnabArmDeployment(bundle.Name, options.Uri, options.Simplify)
 
-	parameterKeys, err := getParameterKeys(*bundle)
+	parameterKeys, err := getParameterKeys(*bundle, false)
 	if err != nil {
 		return err
 	}
@@ -69,7 +73,7 @@ func GenerateNestedDeployment(options GenerateNestedDeploymentOptions) error {
 
 	}
 
-	credentialKeys, err := getCredentialKeys(*bundle)
+	credentialKeys, err := getCredentialKeys(*bundle, false)
 	if err != nil {
 		return err
 	}
@@ -100,75 +104,187 @@ func GenerateNestedDeployment(options GenerateNestedDeploymentOptions) error {
 // GenerateArcTemplate generates an Arc template from bundle metadata
 func GenerateArcTemplate(options common.BundleDetails) (*template.Template, *bundle.Bundle, error) {
 
-	bundle, bundleTag, err := common.GetBundleDetails(options)
+	profile := options.CloudProfile
+	if profile == nil {
+		var err error
+		if profile, err = common.GetCloudProfile(common.DefaultCloudProfileName); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	bundle, bundleTag, _, err := getBundleDetails(options)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	actions := getBundleActions(bundle)
+
 	generatedTemplate, err := template.NewCnabArcTemplate(
 		bundle.Name,
 		bundleTag,
-		options.Dogfood)
+		actions,
+		profile)
 
 	if err != nil {
 		return nil, nil, err
 	}
 
-	parameterKeys, err := getParameterKeys(*bundle)
+	setBundleTagMetadata(generatedTemplate, options, bundleTag)
+	templateParameters := getTemplateParameterNames(generatedTemplate)
+
+	parameterKeys, err := getParameterKeys(*bundle, true)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	parameters := make(map[string]string)
+	// Each action gets its own set of parameters, the set used is selected by the action parameter at deployment time
+	actionParameters := make(map[string]map[string]string, len(actions))
+	for _, action := range actions {
+		actionParameters[action] = make(map[string]string)
+	}
 
 	for _, parameterKey := range parameterKeys {
 
 		parameter := bundle.Parameters[parameterKey]
 		definition := bundle.Definitions[parameter.Definition]
+		isFromOutput := isParamFromOutputOnly(parameterKey, parameter, bundle)
 
-		if (parameter.AppliesTo("install") || (parameter.AppliesTo("upgrade") && !isParamFromOutputOnly(parameterKey, parameter, bundle))) && strings.ToLower(parameterKey) != common.KubeNamespaceParameterName {
-			templateParameter, _, err := genParameter(parameter, definition)
-			if err != nil {
-				return nil, nil, err
+		for _, action := range actions {
+			// Parameters sourced from outputs are only needed for install
+			if !parameter.AppliesTo(action) || (isFromOutput && action != "install") {
+				continue
 			}
 
-			generatedTemplate.Parameters[parameterKey] = *templateParameter
-			parameters[parameterKey] = fmt.Sprintf("[parameters('%s')]", parameterKey)
+			if strings.ToLower(parameterKey) == common.KubeNamespaceParameterName {
+				actionParameters[action][parameterKey] = "reference(resourceId(parameters('customLocationRG'),'Microsoft.ExtendedLocation/customLocations',parameters('customLocationResource'))).namespace"
+				continue
+			}
 
-		}
+			if templateParameters[parameterKey] {
+				return nil, nil, templateParameterNameError("Parameter", parameterKey)
+			}
 
-		if strings.ToLower(parameterKey) == common.KubeNamespaceParameterName {
-			parameters[parameterKey] = "[reference(resourceId(parameters('customLocationRG'),'Microsoft.ExtendedLocation/customLocations/,parameters('customLocationResource'))).namespace]"
+			if _, exists := generatedTemplate.Parameters[parameterKey]; !exists {
+				templateParameter, _, err := genParameter(parameter, definition)
+				if err != nil {
+					return nil, nil, err
+				}
+				generatedTemplate.Parameters[parameterKey] = *templateParameter
+			}
+
+			actionParameters[action][parameterKey] = fmt.Sprintf("parameters('%s')", strings.ReplaceAll(parameterKey, "'", "''"))
 		}
 	}
 
-	if len(parameters) > 0 {
-		if properties, OK := generatedTemplate.Resources[0].Properties.(template.CNABInstallation); OK {
-			properties.Parameters = parameters
-			generatedTemplate.Resources[0].Properties = properties
+	credentialKeys, err := getCredentialKeys(*bundle, true)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		} else {
-			return nil, nil, err
+	credentials := make(map[string]string)
+
+	for _, credentialKey := range credentialKeys {
+		credential := bundle.Credentials[credentialKey]
+		if templateParameters[credentialKey] {
+			return nil, nil, templateParameterNameError("Credential", credentialKey)
+		}
+		generatedTemplate.Parameters[credentialKey] = genCredentialParameter(credential)
+		credentials[credentialKey] = fmt.Sprintf("[parameters('%s')]", strings.ReplaceAll(credentialKey, "'", "''"))
+		// File credentials are entered base64 encoded and are decoded so that the installation gets the content of the file
+		if credential.Path != "" {
+			credentials[credentialKey] = fmt.Sprintf("[base64ToString(parameters('%s'))]", strings.ReplaceAll(credentialKey, "'", "''"))
 		}
 	}
 
-	// TODO: add credentials
+	properties, OK := generatedTemplate.Resources[0].Properties.(template.CNABInstallation)
+	if !OK {
+		return nil, nil, errors.New("Failed to get properties of CNAB installation resource")
+	}
+
+	// The parameters are selected inline as reference() cannot be used in template variables
+	properties.Parameters = fmt.Sprintf("[%s]", arcActionParametersExpression(actions, actionParameters))
+
+	if len(credentials) > 0 {
+		properties.Credentials = credentials
+	}
+
+	generatedTemplate.Resources[0].Properties = properties
 
 	return generatedTemplate, bundle, nil
 }
 
+// arcActionParametersExpression returns the ARM expression that selects the parameters of the installation resource for the action parameter,
+// actionParameters is a map of bundle action to a map of bundle parameter name to the ARM expression for the value of the parameter without the enclosing brackets
+func arcActionParametersExpression(actions []string, actionParameters map[string]map[string]string) string {
+	expression := "json('{}')"
+	for i := len(actions) - 1; i >= 0; i-- {
+		values := actionParameters[actions[i]]
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		object := "json('{}')"
+		if len(names) > 0 {
+			entries := make([]string, len(names))
+			for j, name := range names {
+				entries[j] = fmt.Sprintf("'%s',%s", strings.ReplaceAll(name, "'", "''"), values[name])
+			}
+			object = fmt.Sprintf("createObject(%s)", strings.Join(entries, ","))
+		}
+
+		expression = fmt.Sprintf("if(equals(parameters('action'),'%s'),%s,%s)", strings.ReplaceAll(actions[i], "'", "''"), object, expression)
+	}
+	return expression
+}
+
 // GenerateTemplate generates ARM template from bundle metadata
 func GenerateTemplate(options common.BundleDetails) (*template.Template, *bundle.Bundle, error) {
 
-	bundle, bundleTag, err := common.GetBundleDetails(options)
+	if err := options.ToolVersions.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := common.ValidateMirrorURL(options.ToolsMirrorURL); err != nil {
+		return nil, nil, err
+	}
+
+	bundle, bundleTag, _, err := getBundleDetails(options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	outputs, sensitiveOutputs, err := getOutputs(bundle)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	cloud := options.CloudEnvironment
+	if cloud == nil {
+		if cloud, err = common.GetCloudEnvironment(common.AzureCloudName); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Sensitive outputs are never returned as template outputs, they are only available if they are stored in a key vault
+	var keyVaultOutputs []string
+	if options.KeyVaultOutputs {
+		keyVaultOutputs = sensitiveOutputs
+	}
+
+	actions := getBundleActions(bundle)
+
 	generatedTemplate, err := template.NewCnabArmDriverTemplate(
 		bundle.Name,
 		bundleTag,
-		bundle.Outputs,
+		actions,
+		outputs,
+		keyVaultOutputs,
+		cloud,
+		common.GetAllowedLocations(cloud),
+		options.ToolVersions,
+		options.ToolsMirrorURL,
 		options.Simplify,
 		options.Timeout,
 		options.Debug)
@@ -177,111 +293,149 @@ func GenerateTemplate(options common.BundleDetails) (*template.Template, *bundle
 		return nil, nil, err
 	}
 
-	parameterKeys, err := getParameterKeys(*bundle)
+	setBundleTagMetadata(generatedTemplate, options, bundleTag)
+	templateParameters := getTemplateParameterNames(generatedTemplate)
+
+	parameterKeys, err := getParameterKeys(*bundle, false)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// Each action gets its own parameter set, the set used is selected by the action parameter at deployment time.
+	// Parameter values are converted to strings, porter converts the value to the type of the bundle parameter
+	actionParameters := make(map[string]map[string]string, len(actions))
+	for _, action := range actions {
+		actionParameters[action] = make(map[string]string)
+	}
+
 	for _, parameterKey := range parameterKeys {
 
 		parameter := bundle.Parameters[parameterKey]
 		definition := bundle.Definitions[parameter.Definition]
-		paramEnvVar := template.EnvironmentVariable{
-			Name: common.GetEnvironmentVariableNames().CnabParameterPrefix + parameterKey,
-		}
+		isFromOutput := isParamFromOutputOnly(parameterKey, parameter, bundle)
+
+		for _, action := range actions {
+			// The install and upgrade actions install the bundle if the installation does not exist and upgrade it otherwise so they use the parameters for both install and upgrade,
+			// parameters sourced from outputs are only needed for install
+			if action == "install" || action == "upgrade" {
+				if !parameter.AppliesTo("install") && (!parameter.AppliesTo("upgrade") || isFromOutput) {
+					continue
+				}
+			} else if !parameter.AppliesTo(action) || isFromOutput {
+				continue
+			}
 
-		if options.ReplaceKubeconfig && strings.ToLower(parameterKey) == common.KubeConfigParameterName {
-			paramEnvVar.SecureValue = fmt.Sprintf("[listClusterAdminCredential(resourceId(subscription().subscriptionId,parameters('%s'),'Microsoft.ContainerService/managedClusters',parameters('%s')), '2020-09-01').kubeconfigs[0].value]", common.AKSResourceGroupParameterName, common.AKSResourceParameterName)
-			setAKSParameters(generatedTemplate, bundle)
-		} else if cnabParam, ok := isCnabParam(parameterKey); options.Simplify && ok {
-			paramEnvVar.Value = fmt.Sprintf("[variables('%s')]", cnabParam)
-		} else if parameter.AppliesTo("install") || (parameter.AppliesTo("upgrade") && !isParamFromOutputOnly(parameterKey, parameter, bundle)) {
-			templateParameter, isSensitive, err := genParameter(parameter, definition)
-			if err != nil {
-				return nil, nil, err
+			if options.ReplaceKubeconfig && strings.ToLower(parameterKey) == common.KubeConfigParameterName {
+				actionParameters[action][parameterKey] = aksKubeConfigExpression()
+				setAKSParameters(generatedTemplate, bundle)
+				continue
 			}
 
-			generatedTemplate.Parameters[parameterKey] = *templateParameter
+			if cnabParam, ok := isCnabParam(parameterKey); options.Simplify && ok {
+				actionParameters[action][parameterKey] = fmt.Sprintf("string(variables('%s'))", cnabParam)
+				continue
+			}
 
-			if isSensitive {
-				paramEnvVar.SecureValue = fmt.Sprintf("[parameters('%s')]", parameterKey)
-			} else {
-				paramEnvVar.Value = fmt.Sprintf("[parameters('%s')]", parameterKey)
+			if templateParameters[parameterKey] {
+				return nil, nil, templateParameterNameError("Parameter", parameterKey)
 			}
 
-		} else {
-			continue
-		}
+			if _, exists := generatedTemplate.Parameters[parameterKey]; !exists {
+				templateParameter, _, err := genParameter(parameter, definition)
+				if err != nil {
+					return nil, nil, err
+				}
+				generatedTemplate.Parameters[parameterKey] = *templateParameter
+			}
 
-		if err = generatedTemplate.SetDeploymentScriptEnvironmentVariable(paramEnvVar); err != nil {
-			return nil, nil, err
+			actionParameters[action][parameterKey] = fmt.Sprintf("string(parameters('%s'))", parameterKey)
 		}
 	}
 
-	credentialKeys, err := getCredentialKeys(*bundle)
+	if err = generatedTemplate.SetDeploymentScriptParameterSets(actionParameters); err != nil {
+		return nil, nil, err
+	}
+
+	credentialKeys, err := getCredentialKeys(*bundle, false)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	credentialValues := make(map[string]string)
+	credentialFileValues := make(map[string]string)
 	for _, credentialKey := range credentialKeys {
 
 		credential := bundle.Credentials[credentialKey]
 
-		var metadata template.Metadata
-		var description string
-		var defaultValue interface{}
-		var envVarName string
-
-		if credential.Description != "" {
-			description = credential.Description
+		var value string
+		if options.ReplaceKubeconfig && strings.ToLower(credentialKey) == common.KubeConfigParameterName {
+			value = aksKubeConfigExpression()
+			setAKSParameters(generatedTemplate, bundle)
+		} else if cnabParam, ok := isCnabParam(credentialKey); options.Simplify && ok {
+			value = fmt.Sprintf("variables('%s')", cnabParam)
+		} else {
+			if templateParameters[credentialKey] {
+				return nil, nil, templateParameterNameError("Credential", credentialKey)
+			}
+			generatedTemplate.Parameters[credentialKey] = genCredentialParameter(credential)
+			value = fmt.Sprintf("parameters('%s')", credentialKey)
 		}
 
+		// File credentials are base64 encoded, the deployment script decodes them to a file so that binary files are not corrupted
 		if credential.Path != "" {
-			if description != "" {
-				description += " "
-			}
-			description += "(Enter base64 encoded representation of file)"
-			envVarName = common.GetEnvironmentVariableNames().CnabCredentialFilePrefix + credentialKey
-		} else {
-			envVarName = common.GetEnvironmentVariableNames().CnabCredentialPrefix + credentialKey
+			credentialFileValues[credentialKey] = value
+			continue
 		}
+		credentialValues[credentialKey] = value
+	}
 
-		if description != "" {
-			metadata = template.Metadata{
-				Description: description,
-			}
-		}
+	if err = generatedTemplate.SetDeploymentScriptCredentialSet(credentialValues, credentialFileValues); err != nil {
+		return nil, nil, err
+	}
 
-		if !credential.Required {
-			defaultValue = ""
-		}
+	return generatedTemplate, bundle, nil
+}
 
-		credEnvVar := template.EnvironmentVariable{
-			Name: envVarName,
-		}
+// setBundleTagMetadata records the tag that was pulled in the template metadata when the template references the bundle by digest, so that the tag the template was generated from is not lost
+func setBundleTagMetadata(generatedTemplate *template.Template, options common.BundleDetails, bundleTag string) {
+	if len(options.BundlePullOptions.Tag) > 0 && bundleTag != options.BundlePullOptions.Tag {
+		generatedTemplate.SetMetadata(template.BundleTagMetadataName, options.BundlePullOptions.Tag)
+	}
+}
 
-		if options.ReplaceKubeconfig && strings.ToLower(credentialKey) == common.KubeConfigParameterName {
-			credEnvVar.SecureValue = fmt.Sprintf("[listClusterAdminCredential(resourceId(subscription().subscriptionId,parameters('%s'),'Microsoft.ContainerService/managedClusters',parameters('%s')), '2020-09-01').kubeconfigs[0].value]", common.AKSResourceGroupParameterName, common.AKSResourceParameterName)
-			setAKSParameters(generatedTemplate, bundle)
-		} else {
-			if cnabParam, ok := isCnabParam(credentialKey); options.Simplify && ok {
-				credEnvVar.SecureValue = fmt.Sprintf("[variables('%s')]", cnabParam)
-			} else {
-				generatedTemplate.Parameters[credentialKey] = template.Parameter{
-					Type:         "securestring",
-					Metadata:     &metadata,
-					DefaultValue: defaultValue,
-				}
-				credEnvVar.SecureValue = fmt.Sprintf("[parameters('%s')]", credentialKey)
-			}
+// aksKubeConfigExpression returns the ARM expression for the base64 encoded admin kubeconfig of the AKS cluster referenced by the AKS parameters
+func aksKubeConfigExpression() string {
+	return fmt.Sprintf("listClusterAdminCredential(resourceId(subscription().subscriptionId,parameters('%s'),'Microsoft.ContainerService/managedClusters',parameters('%s')), '2020-09-01').kubeconfigs[0].value", common.AKSResourceGroupParameterName, common.AKSResourceParameterName)
+}
+
+// genCredentialParameter generates a securestring template parameter for a bundle credential, file credentials are expected to be base64 encoded
+func genCredentialParameter(credential bundle.Credential) template.Parameter {
+	var metadata template.Metadata
+	description := credential.Description
+
+	if credential.Path != "" {
+		if description != "" {
+			description += " "
 		}
+		description += "(Enter base64 encoded representation of file)"
+	}
 
-		if err = generatedTemplate.SetDeploymentScriptEnvironmentVariable(credEnvVar); err != nil {
-			return nil, nil, err
+	if description != "" {
+		metadata = template.Metadata{
+			Description: description,
 		}
+	}
 
+	var defaultValue interface{}
+	if !credential.Required {
+		defaultValue = ""
+	}
+
+	return template.Parameter{
+		Type:         "securestring",
+		Metadata:     &metadata,
+		DefaultValue: defaultValue,
 	}
-	return generatedTemplate, bundle, nil
 }
 
 func genParameter(parameter bundle.Parameter, definition *definition.Schema) (*template.Parameter, bool, error) {
@@ -351,7 +505,7 @@ func genParameter(parameter bundle.Parameter, definition *definition.Schema) (*t
 }
 
 func GenerateCustomRP(options common.BundleDetails) (*template.Template, *bundle.Bundle, error) {
-	bundle, bundleTag, err := common.GetBundleDetails(options)
+	bundle, bundleTag, _, err := getBundleDetails(options)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -368,15 +522,26 @@ func GenerateCustomRP(options common.BundleDetails) (*template.Template, *bundle
 		typeName = customTypeInfo.Type
 	}
 
+	cloud := options.CloudEnvironment
+	if cloud == nil {
+		if cloud, err = common.GetCloudEnvironment(common.AzureCloudName); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	customRPTemplate, err := template.NewCnabCustomRPTemplate(
 		bundle.Name,
 		bundleTag,
-		customTypeInfo)
+		customTypeInfo,
+		cloud,
+		common.GetAllowedLocations(cloud))
 
 	if err != nil {
 		return nil, nil, err
 	}
 
+	setBundleTagMetadata(customRPTemplate, options, bundleTag)
+
 	customActions := getCustomActions(bundle, customTypeInfo)
 
 	for i := range customActions {
@@ -409,7 +574,7 @@ func GenerateCustomRP(options common.BundleDetails) (*template.Template, *bundle
 			Properties: customResourceProperties,
 		}
 
-		parameterKeys, err := getParameterKeys(*bundle)
+		parameterKeys, err := getParameterKeys(*bundle, true)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -428,44 +593,13 @@ func GenerateCustomRP(options common.BundleDetails) (*template.Template, *bundle
 			}
 		}
 
-		credentialKeys, err := getCredentialKeys(*bundle)
+		credentialKeys, err := getCredentialKeys(*bundle, true)
 		if err != nil {
 			return nil, nil, err
 		}
 
 		for _, credentialKey := range credentialKeys {
-			credential := bundle.Credentials[credentialKey]
-
-			var metadata template.Metadata
-			var description string
-			var defaultValue interface{}
-
-			if credential.Description != "" {
-				description = credential.Description
-			}
-
-			if credential.Path != "" {
-				if description != "" {
-					description += " "
-				}
-				description += "(Enter base64 encoded representation of file)"
-			}
-
-			if description != "" {
-				metadata = template.Metadata{
-					Description: description,
-				}
-			}
-
-			if !credential.Required {
-				defaultValue = ""
-			}
-
-			customRPTemplate.Parameters[credentialKey] = template.Parameter{
-				Type:         "securestring",
-				Metadata:     &metadata,
-				DefaultValue: defaultValue,
-			}
+			customRPTemplate.Parameters[credentialKey] = genCredentialParameter(bundle.Credentials[credentialKey])
 			customResourceProperties.Credentials[credentialKey] = fmt.Sprintf("[parameters('%s')]", credentialKey)
 		}
 		customRPTemplate.Resources = append(customRPTemplate.Resources, customResource)
@@ -475,22 +609,15 @@ func GenerateCustomRP(options common.BundleDetails) (*template.Template, *bundle
 			Value: fmt.Sprintf("[reference(concat(resourceId('Microsoft.CustomProviders/resourceProviders','%s'),'/%s/',deployment().name)).Installation]", template.CustomRPName, typeName),
 		}
 
-		for k, v := range bundle.Outputs {
-			if v.AppliesTo("install") || v.AppliesTo("upgrade") {
-				sensitive, err := bundle.IsOutputSensitive(k)
-				if err != nil {
-					return nil, nil, fmt.Errorf("Failed to check of output %s is sensitive: %w", k, err)
-				}
-				if !sensitive {
-					armType, err := toARMType(bundle.Definitions[v.Definition].Type.(string), false)
-					if err != nil {
-						return nil, nil, fmt.Errorf("Failed to get ARM type of output %s: %w", k, err)
-					}
-					customRPTemplate.Outputs[k] = template.Output{
-						Type:  armType,
-						Value: fmt.Sprintf("[reference(concat(resourceId('Microsoft.CustomProviders/resourceProviders','%s'),'/%s/',deployment().name)).%s]", template.CustomRPName, typeName, k),
-					}
-				}
+		outputs, _, err := getOutputs(bundle)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for k, armType := range outputs {
+			customRPTemplate.Outputs[k] = template.Output{
+				Type:  armType,
+				Value: fmt.Sprintf("[reference(concat(resourceId('Microsoft.CustomProviders/resourceProviders','%s'),'/%s/',deployment().name)).%s]", template.CustomRPName, typeName, k),
 			}
 		}
 
@@ -518,6 +645,8 @@ func GenerateFiles(options common.BundleDetails) error {
 
 	if options.CustomRPTemplate {
 		generatedTemplate, bundle, err = GenerateCustomRP(options)
+	} else if options.ArcTemplate {
+		generatedTemplate, bundle, err = GenerateArcTemplate(options)
 	} else {
 		generatedTemplate, bundle, err = GenerateTemplate(options)
 	}
@@ -525,13 +654,13 @@ func GenerateFiles(options common.BundleDetails) error {
 		return fmt.Errorf("Error generating template: %w", err)
 	}
 
-	err = common.WriteOutput(options.OutputWriter, generatedTemplate, options.Indent)
+	err = WriteTemplate(options.OutputWriter, generatedTemplate, options.Options)
 	if err != nil {
 		return fmt.Errorf("Error writing output file: %w", err)
 	}
 
 	if options.GenerateUI {
-		ui, err := uidefinition.NewCreateUIDefinition(bundle.Name, bundle.Description, generatedTemplate, options.Simplify, options.ReplaceKubeconfig, bundle.Custom, options.CustomRPTemplate, options.IncludeCustomResource, options.ArcTemplate, options.Dogfood)
+		ui, err := uidefinition.NewCreateUIDefinition(bundle.Name, bundle.Description, generatedTemplate, options.Simplify, options.ReplaceKubeconfig, bundle.Custom, options.CustomRPTemplate, options.IncludeCustomResource, options.ArcTemplate, options.CloudProfile)
 		if err != nil {
 			return fmt.Errorf("Failed to gernerate UI definition, %w", err)
 		}
@@ -543,9 +672,18 @@ func GenerateFiles(options common.BundleDetails) error {
 	return nil
 }
 
+// WriteTemplate writes the generated template in the format specified in the options
+func WriteTemplate(writer io.Writer, generatedTemplate *template.Template, options common.Options) error {
+	if options.Format == common.OutputFormatBicep {
+		return generatedTemplate.WriteBicep(writer)
+	}
+
+	return common.WriteOutput(writer, generatedTemplate, options.Indent)
+}
+
 func GenerateManagedAppDefinitionTemplate(options common.BundleDetails, packageUri string) (*template.Template, *bundle.Bundle, error) {
 
-	bundle, _, err := common.GetBundleDetails(options)
+	bundle, _, _, err := getBundleDetails(options)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -625,21 +763,47 @@ func toARMType(jsonType string, isSensitive bool) (string, error) {
 	case "object", "array":
 		armType = jsonType
 	default:
-		err = fmt.Errorf("Unable to convert type '%s' to ARM template parameter type", jsonType)
+		err = invalidBundleError(fmt.Errorf("Unable to convert type '%s' to ARM template parameter type", jsonType))
 	}
 
 	return armType, err
 }
 
-func getParameterKeys(bundle bundle.Bundle) ([]string, error) {
+// getOutputs returns the ARM type of each non sensitive bundle output and the sorted names of the sensitive bundle outputs that are produced by install or upgrade
+func getOutputs(bundle *bundle.Bundle) (map[string]string, []string, error) {
+	outputs := make(map[string]string)
+	var sensitiveOutputs []string
+	for k, v := range bundle.Outputs {
+		if v.AppliesTo("install") || v.AppliesTo("upgrade") {
+			sensitive, err := bundle.IsOutputSensitive(k)
+			if err != nil {
+				return nil, nil, invalidBundleError(fmt.Errorf("Failed to check of output %s is sensitive: %w", k, err))
+			}
+			if sensitive {
+				sensitiveOutputs = append(sensitiveOutputs, k)
+				continue
+			}
+			armType, err := toARMType(bundle.Definitions[v.Definition].Type.(string), false)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Failed to get ARM type of output %s: %w", k, err)
+			}
+			outputs[k] = armType
+		}
+	}
+	sort.Strings(sensitiveOutputs)
+	return outputs, sensitiveOutputs, nil
+}
+
+// getParameterKeys returns the sorted bundle parameter names, envVarNames requires names usable as environment variables (custom RP and Arc templates)
+func getParameterKeys(bundle bundle.Bundle, envVarNames bool) ([]string, error) {
 	// Sort parameters, because Go randomizes order when iterating a map
 	var parameterKeys []string
 	for parameterKey := range bundle.Parameters {
 		if isPorterParam(parameterKey) {
 			continue
 		}
-		if strings.Contains(parameterKey, "-") {
-			return nil, fmt.Errorf("Invalid Parameter name: %s. ARM template generation requires parameter names that can be used as environment variables", parameterKey)
+		if envVarNames && strings.Contains(parameterKey, "-") {
+			return nil, invalidBundleError(fmt.Errorf("Invalid Parameter name: %s. Template generation requires parameter names that can be used as environment variables", parameterKey))
 		}
 		parameterKeys = append(parameterKeys, parameterKey)
 	}
@@ -652,18 +816,31 @@ func isPorterParam(parameterKey string) bool {
 	// Test for porter specific parameters, these do not need to be included in template and also have invalid env var names
 	porteroutput, err := regexp.Match("^porter-[\\S]*-output$", []byte(parameterKey))
 	if err != nil {
-		log.L.Debugf("Error trying to match on porter-*-output: %v", err)
+		log.Debugf("Error trying to match on porter-*-output: %v", err)
 		porteroutput = true
 	}
 	porterdepoutput, err := regexp.Match(`porter-[\\S]*-[\\S]*-dep-output`, []byte(parameterKey))
 	if err != nil {
-		log.L.Debugf("Error trying to match on porter-*-*-dep-output: %v", err)
+		log.Debugf("Error trying to match on porter-*-*-dep-output: %v", err)
 		porterdepoutput = true
 	}
 
 	return parameterKey == "porter-debug" || porteroutput || porterdepoutput
 }
 
+// getBundleActions returns the built in actions followed by the sorted custom actions of the bundle
+func getBundleActions(bundle *bundle.Bundle) []string {
+	actions := append([]string{}, common.BuiltInActions...)
+	var customActions []string
+	for name := range bundle.Actions {
+		if isCustomAction(name) {
+			customActions = append(customActions, name)
+		}
+	}
+	sort.Strings(customActions)
+	return append(actions, customActions...)
+}
+
 func getCustomActions(bundle *bundle.Bundle, customTypeInfo *template.Type) []string {
 	var actions []string
 	for name := range bundle.Actions {
@@ -714,43 +891,43 @@ func getCustomTypeInfo(bundle *bundle.Bundle) (*template.Type, error) {
 	var customType template.Type
 	jsonData, err := json.Marshal(bundle.Custom["com.azure.arm"])
 	if err != nil {
-		return nil, fmt.Errorf("Unable to serialise Custom Type settings to JSON %w", err)
+		return nil, invalidBundleError(fmt.Errorf("Unable to serialise Custom Type settings to JSON %w", err))
 	}
 	err = json.Unmarshal(jsonData, &customType)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to de-serialise Custom Type settings from JSON %w", err)
+		return nil, invalidBundleError(fmt.Errorf("Unable to de-serialise Custom Type settings from JSON %w", err))
 	}
 	if customType.Type == "" {
-		return nil, errors.New("Custom Type specified with no type property")
+		return nil, invalidBundleError(errors.New("Custom Type specified with no type property"))
 	}
 	if customType.Id == "" {
-		return nil, fmt.Errorf("Id not specified for custom type %s", customType.Type)
+		return nil, invalidBundleError(fmt.Errorf("Id not specified for custom type %s", customType.Type))
 	}
 	if _, ok := bundle.Parameters[customType.Id]; !ok {
-		return nil, fmt.Errorf("Bundle Parameter %s specified as Id for Type %s does not exist", customType.Id, customType.Type)
+		return nil, invalidBundleError(fmt.Errorf("Bundle Parameter %s specified as Id for Type %s does not exist", customType.Id, customType.Type))
 	}
 	for childTypeName, childType := range customType.ChildTypes {
 		actions := []string{"CreateUpdateAction", "DeleteAction", "GetAction", "ListAction"}
 		for _, childAction := range actions {
 			fieldValue := reflect.ValueOf(&childType).Elem().FieldByName(childAction).String()
 			if fieldValue == "" {
-				return nil, fmt.Errorf("Action %s for Operation %s for Child Type %s is not set", fieldValue, childAction, childTypeName)
+				return nil, invalidBundleError(fmt.Errorf("Action %s for Operation %s for Child Type %s is not set", fieldValue, childAction, childTypeName))
 			} else {
 				if _, ok := bundle.Actions[fieldValue]; !ok {
-					return nil, fmt.Errorf("Action %s for Operation %s for Child Type %s does not exist", fieldValue, childAction, childTypeName)
+					return nil, invalidBundleError(fmt.Errorf("Action %s for Operation %s for Child Type %s does not exist", fieldValue, childAction, childTypeName))
 				} else {
 					if customAction := isCustomAction(fieldValue); !customAction {
-						return nil, fmt.Errorf("Action %s for for Operation %s Child Type %s is not a custom action", fieldValue, childAction, childTypeName)
+						return nil, invalidBundleError(fmt.Errorf("Action %s for for Operation %s Child Type %s is not a custom action", fieldValue, childAction, childTypeName))
 					}
 				}
 			}
 		}
 		for actionName, childTypeAction := range childType.Actions {
 			if _, ok := bundle.Actions[childTypeAction]; !ok {
-				return nil, fmt.Errorf("Custom action %s for action name %s for Child Type %s does not exist", childTypeAction, actionName, childTypeName)
+				return nil, invalidBundleError(fmt.Errorf("Custom action %s for action name %s for Child Type %s does not exist", childTypeAction, actionName, childTypeName))
 			} else {
 				if customAction := isCustomAction(childTypeAction); !customAction {
-					return nil, fmt.Errorf("Custom action %s for action name %s for Child Type %s is not a custom action", childTypeAction, actionName, childTypeName)
+					return nil, invalidBundleError(fmt.Errorf("Custom action %s for action name %s for Child Type %s is not a custom action", childTypeAction, actionName, childTypeName))
 				}
 			}
 		}
@@ -759,13 +936,32 @@ func getCustomTypeInfo(bundle *bundle.Bundle) (*template.Type, error) {
 	return &customType, nil
 }
 
-func getCredentialKeys(bundle bundle.Bundle) ([]string, error) {
+// invalidBundleError classifies an error caused by the content of a bundle that cannot be converted
+func invalidBundleError(err error) error {
+	return helpers.NewError(helpers.ErrorKindInvalidBundle, err)
+}
+
+// getTemplateParameterNames returns the names of the parameters of a template before any bundle parameters or credentials are added to it
+func getTemplateParameterNames(generatedTemplate *template.Template) map[string]bool {
+	names := make(map[string]bool, len(generatedTemplate.Parameters))
+	for name := range generatedTemplate.Parameters {
+		names[name] = true
+	}
+	return names
+}
+
+// templateParameterNameError returns the error for a bundle parameter or credential whose name is the name of a parameter that the template defines
+func templateParameterNameError(kind string, name string) error {
+	return invalidBundleError(fmt.Errorf("Invalid %s name: %s. The name is used by a parameter of the generated template", kind, name))
+}
+
+// getCredentialKeys returns the sorted bundle credential names, envVarNames requires names usable as environment variables (custom RP and Arc templates)
+func getCredentialKeys(bundle bundle.Bundle, envVarNames bool) ([]string, error) {
 	// Sort credentials, because Go randomizes order when iterating a map
 	var credentialKeys []string
 	for credentialKey := range bundle.Credentials {
-
-		if strings.Contains(credentialKey, "-") {
-			return nil, fmt.Errorf("Invalid Credential name: %s.ARM template generation requires credential names that can be used as environment variables", credentialKey)
+		if envVarNames && strings.Contains(credentialKey, "-") {
+			return nil, invalidBundleError(fmt.Errorf("Invalid Credential name: %s. Template generation requires credential names that can be used as environment variables", credentialKey))
 		}
 		credentialKeys = append(credentialKeys, credentialKey)
 	}
@@ -3,9 +3,16 @@ package generator
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"get.porter.sh/porter/pkg/porter"
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/cnabio/cnab-to-oci/relocation"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/common"
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/template"
 	"gotest.tools/assert"
 )
 
@@ -98,3 +105,397 @@ func TestGenerateSimpleTemplate(t *testing.T) {
 
 	assert.Equal(t, expected, generated)
 }
+
+// writeTestBundle writes bundle to a file in a temporary directory and returns the options used to generate a template from it,
+// the returned function removes the directory
+func writeTestBundle(t *testing.T, bundle string) (common.BundleDetails, func()) {
+	dir, err := ioutil.TempDir("", "generator")
+	assert.NilError(t, err)
+
+	bundlePath := filepath.Join(dir, "bundle.json")
+	assert.NilError(t, ioutil.WriteFile(bundlePath, []byte(bundle), 0644))
+
+	options := common.BundleDetails{
+		BundleLoc: bundlePath,
+		Options: common.Options{
+			BundlePullOptions: &porter.BundlePullOptions{},
+		},
+	}
+	return options, func() { os.RemoveAll(dir) }
+}
+
+const arcTestBundle = `{
+	"name": "arc-test",
+	"invocationImages": [{"imageType": "docker", "image": "example.com/arc-test:v1"}],
+	"actions": {"status": {}, "it's": {}},
+	"definitions": {"string": {"type": "string", "default": "value"}},
+	"parameters": {
+		"name": {"definition": "string"},
+		"o'brien": {"definition": "string", "applyTo": ["install", "it's"]},
+		"namespace": {"definition": "string", "applyTo": ["install"]}
+	},
+	"credentials": {
+		"token": {"env": "TOKEN"},
+		"it's_file": {"path": "/cnab/app/file"}
+	}
+}`
+
+func TestGenerateArcTemplate(t *testing.T) {
+	options, cleanup := writeTestBundle(t, arcTestBundle)
+	defer cleanup()
+
+	generatedTemplate, _, err := GenerateArcTemplate(options)
+	assert.NilError(t, err)
+
+	assert.DeepEqual(t, []string{"install", "upgrade", "uninstall", "it's", "status"}, generatedTemplate.Parameters["action"].AllowedValues)
+
+	properties := generatedTemplate.Resources[0].Properties.(template.CNABInstallation)
+	namespace := "reference(resourceId(parameters('customLocationRG'),'Microsoft.ExtendedLocation/customLocations',parameters('customLocationResource'))).namespace"
+	expected := "[" +
+		"if(equals(parameters('action'),'install'),createObject('name',parameters('name'),'namespace'," + namespace + ",'o''brien',parameters('o''brien'))," +
+		"if(equals(parameters('action'),'upgrade'),createObject('name',parameters('name'))," +
+		"if(equals(parameters('action'),'uninstall'),createObject('name',parameters('name'))," +
+		"if(equals(parameters('action'),'it''s'),createObject('name',parameters('name'),'o''brien',parameters('o''brien'))," +
+		"if(equals(parameters('action'),'status'),createObject('name',parameters('name'))," +
+		"json('{}'))))))]"
+	assert.Equal(t, expected, properties.Parameters)
+
+	assert.DeepEqual(t, map[string]string{
+		"token":     "[parameters('token')]",
+		"it's_file": "[base64ToString(parameters('it''s_file'))]",
+	}, properties.Credentials)
+
+	// The namespace is taken from the custom location so it is not a template parameter
+	_, exists := generatedTemplate.Parameters["namespace"]
+	assert.Assert(t, !exists)
+	_, exists = generatedTemplate.Parameters["o'brien"]
+	assert.Assert(t, exists)
+}
+
+func TestGenerateEnvironmentVariableNames(t *testing.T) {
+	parameterBundle := `{
+	"name": "names-test",
+	"invocationImages": [{"imageType": "docker", "image": "example.com/names-test:v1"}],
+	"definitions": {"string": {"type": "string", "default": "value"}},
+	"parameters": {"my-param": {"definition": "string"}}
+}`
+	credentialBundle := `{
+	"name": "names-test",
+	"invocationImages": [{"imageType": "docker", "image": "example.com/names-test:v1"}],
+	"credentials": {"my-cred": {"env": "MY_CRED"}}
+}`
+	generateCustomRP := func(options common.BundleDetails) error {
+		options.IncludeCustomResource = true
+		_, _, err := GenerateCustomRP(options)
+		return err
+	}
+	generateArcTemplate := func(options common.BundleDetails) error {
+		_, _, err := GenerateArcTemplate(options)
+		return err
+	}
+	generateTemplate := func(options common.BundleDetails) error {
+		_, _, err := GenerateTemplate(options)
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		bundle   string
+		generate func(common.BundleDetails) error
+		expected string
+	}{
+		{"arc parameter", parameterBundle, generateArcTemplate, "Invalid Parameter name: my-param."},
+		{"arc credential", credentialBundle, generateArcTemplate, "Invalid Credential name: my-cred."},
+		{"custom rp parameter", parameterBundle, generateCustomRP, "Invalid Parameter name: my-param."},
+		{"custom rp credential", credentialBundle, generateCustomRP, "Invalid Credential name: my-cred."},
+		{"template parameter", parameterBundle, generateTemplate, ""},
+		{"template credential", credentialBundle, generateTemplate, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, cleanup := writeTestBundle(t, test.bundle)
+			defer cleanup()
+
+			err := test.generate(options)
+			if test.expected == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, test.expected)
+			assert.Equal(t, helpers.ErrorKindInvalidBundle, helpers.KindOf(err))
+		})
+	}
+}
+
+const outputsTestBundle = `{
+	"name": "outputs-test",
+	"invocationImages": [{"imageType": "docker", "image": "example.com/outputs-test:v1"}],
+	"definitions": {
+		"string": {"type": "string"},
+		"integer": {"type": "integer"},
+		"boolean": {"type": "boolean"},
+		"object": {"type": "object"}
+	},
+	"outputs": {
+		"host": {"definition": "string"},
+		"port": {"definition": "integer", "applyTo": ["upgrade"]},
+		"ready": {"definition": "boolean"},
+		"config": {"definition": "object"},
+		"health": {"definition": "string", "applyTo": ["status"]}
+	}
+}`
+
+const sensitiveOutputsTestBundle = `{
+	"name": "sensitive-outputs-test",
+	"invocationImages": [{"imageType": "docker", "image": "example.com/sensitive-outputs-test:v1"}],
+	"definitions": {
+		"string": {"type": "string"},
+		"secret": {"type": "string", "writeOnly": true}
+	},
+	"outputs": {
+		"host": {"definition": "string"},
+		"password": {"definition": "secret"},
+		"token": {"definition": "secret"}
+	}
+}`
+
+const actionsTestBundle = `{
+	"name": "actions-test",
+	"invocationImages": [{"imageType": "docker", "image": "example.com/actions-test:v1"}],
+	"actions": {"status": {}, "restart": {}},
+	"definitions": {
+		"string": {"type": "string", "default": "value"},
+		"integer": {"type": "integer", "default": 1},
+		"boolean": {"type": "boolean", "default": false}
+	},
+	"parameters": {
+		"name": {"definition": "string"},
+		"replicas": {"definition": "integer", "applyTo": ["install"]},
+		"force": {"definition": "boolean", "applyTo": ["restart"]}
+	}
+}`
+
+const testDigest = "sha256:4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945"
+
+func TestGenerateTemplateFromBundle(t *testing.T) {
+	tests := []struct {
+		name   string
+		bundle string
+		setup  func(*common.BundleDetails)
+		check  func(*testing.T, *template.Template)
+	}{
+		{
+			name:   "typed outputs",
+			bundle: outputsTestBundle,
+			check: func(t *testing.T, generatedTemplate *template.Template) {
+				// Outputs that are not produced by install or upgrade are not template outputs
+				assert.DeepEqual(t, map[string]string{
+					"host":   "string",
+					"port":   "int",
+					"ready":  "bool",
+					"config": "object",
+				}, outputTypes(generatedTemplate))
+				assert.Equal(t, "[reference(resourceId('Microsoft.Resources/deploymentScripts',variables('deploymentScriptResourceName')), '2019-10-01-preview').outputs['port']]", generatedTemplate.Outputs["port"].Value)
+			},
+		},
+		{
+			name:   "sensitive outputs",
+			bundle: sensitiveOutputsTestBundle,
+			check: func(t *testing.T, generatedTemplate *template.Template) {
+				assert.DeepEqual(t, map[string]string{"host": "string"}, outputTypes(generatedTemplate))
+				assert.Assert(t, !hasResourceType(generatedTemplate, "Microsoft.KeyVault/vaults"))
+				_, exists := generatedTemplate.Parameters["keyvault_name"]
+				assert.Assert(t, !exists)
+			},
+		},
+		{
+			name:   "sensitive outputs in key vault",
+			bundle: sensitiveOutputsTestBundle,
+			setup: func(options *common.BundleDetails) {
+				options.KeyVaultOutputs = true
+			},
+			check: func(t *testing.T, generatedTemplate *template.Template) {
+				// The secret id of each sensitive output is returned in place of the value
+				assert.DeepEqual(t, map[string]string{
+					"host":     "string",
+					"password": "string",
+					"token":    "string",
+				}, outputTypes(generatedTemplate))
+				assert.Assert(t, hasResourceType(generatedTemplate, "Microsoft.KeyVault/vaults"))
+				_, exists := generatedTemplate.Parameters["keyvault_name"]
+				assert.Assert(t, exists)
+				assert.Assert(t, strings.Contains(scriptContent(t, generatedTemplate), "OUTPUT_NAME in password token"))
+			},
+		},
+		{
+			name:   "action parameter sets",
+			bundle: actionsTestBundle,
+			check: func(t *testing.T, generatedTemplate *template.Template) {
+				assert.DeepEqual(t, []string{"install", "upgrade", "uninstall", "restart", "status"}, generatedTemplate.Parameters["action"].AllowedValues)
+
+				name := "createObject('Name','name','Source',createObject('Value',string(parameters('name'))))"
+				replicas := "createObject('Name','replicas','Source',createObject('Value',string(parameters('replicas'))))"
+				force := "createObject('Name','force','Source',createObject('Value',string(parameters('force'))))"
+				// install and upgrade both use the parameters that apply to install or upgrade
+				expected := "[string(createObject('Name',parameters('cnab_installation_name'),'Parameters'," +
+					"if(equals(parameters('action'),'install'),createArray(" + name + "," + replicas + ")," +
+					"if(equals(parameters('action'),'restart'),createArray(" + force + "," + name + ")," +
+					"if(equals(parameters('action'),'status'),createArray(" + name + ")," +
+					"if(equals(parameters('action'),'uninstall'),createArray(" + name + ")," +
+					"if(equals(parameters('action'),'upgrade'),createArray(" + name + "," + replicas + ")," +
+					"json('[]'))))))))]"
+				parameterSet := environmentVariable(t, generatedTemplate, common.GetEnvironmentVariableNames().CnabParameterSet)
+				assert.Equal(t, expected, parameterSet.SecureValue)
+			},
+		},
+		{
+			name:   "pinned digest",
+			bundle: outputsTestBundle,
+			setup: func(options *common.BundleDetails) {
+				options.BundlePullOptions.Tag = "example.com/outputs-test:v1"
+				options.PinDigest = true
+				// The bundle is read from the file in place of the registry, the reference returned is the digest that the tag resolved to
+				getBundleDetails = func(options common.BundleDetails) (*bundle.Bundle, string, relocation.ImageRelocationMap, error) {
+					options.BundlePullOptions = &porter.BundlePullOptions{}
+					bun, _, _, err := common.GetBundleDetails(options)
+					return bun, "example.com/outputs-test@" + testDigest, nil, err
+				}
+			},
+			check: func(t *testing.T, generatedTemplate *template.Template) {
+				assert.Assert(t, strings.Contains(scriptContent(t, generatedTemplate), "BUNDLE_REFERENCE='example.com/outputs-test@"+testDigest+"'"))
+				assert.Equal(t, "example.com/outputs-test:v1", generatedTemplate.Metadata[template.BundleTagMetadataName])
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, cleanup := writeTestBundle(t, test.bundle)
+			defer cleanup()
+			defer func() { getBundleDetails = common.GetBundleDetails }()
+			if test.setup != nil {
+				test.setup(&options)
+			}
+
+			generatedTemplate, _, err := GenerateTemplate(options)
+			assert.NilError(t, err)
+			test.check(t, generatedTemplate)
+		})
+	}
+}
+
+// outputTypes returns the type of each output of a template
+func outputTypes(generatedTemplate *template.Template) map[string]string {
+	types := make(map[string]string, len(generatedTemplate.Outputs))
+	for name, output := range generatedTemplate.Outputs {
+		types[name] = output.Type
+	}
+	return types
+}
+
+// hasResourceType returns true if a template has a resource of resourceType
+func hasResourceType(generatedTemplate *template.Template, resourceType string) bool {
+	for _, resource := range generatedTemplate.Resources {
+		if resource.Type == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptContent returns the script of the deployment script in a template
+func scriptContent(t *testing.T, generatedTemplate *template.Template) string {
+	resource, err := generatedTemplate.FindResource(template.DeploymentScriptName)
+	assert.NilError(t, err)
+	properties, ok := resource.Properties.(template.DeploymentScriptProperties)
+	assert.Assert(t, ok)
+	return properties.ScriptContent
+}
+
+// environmentVariable returns the deployment script environment variable called name
+func environmentVariable(t *testing.T, generatedTemplate *template.Template, name string) template.EnvironmentVariable {
+	resource, err := generatedTemplate.FindResource(template.DeploymentScriptName)
+	assert.NilError(t, err)
+	properties, ok := resource.Properties.(template.DeploymentScriptProperties)
+	assert.Assert(t, ok)
+	for _, environmentVariable := range properties.EnvironmentVariables {
+		if environmentVariable.Name == name {
+			return environmentVariable
+		}
+	}
+	t.Fatalf("Deployment script environment variable %s not found", name)
+	return template.EnvironmentVariable{}
+}
+
+func TestGenerateTemplateParameterNames(t *testing.T) {
+	parameterBundle := func(name string) string {
+		return `{
+	"name": "names-test",
+	"invocationImages": [{"imageType": "docker", "image": "example.com/names-test:v1"}],
+	"definitions": {"string": {"type": "string", "default": "value"}},
+	"parameters": {"` + name + `": {"definition": "string"}}
+}`
+	}
+	credentialBundle := func(name string) string {
+		return `{
+	"name": "names-test",
+	"invocationImages": [{"imageType": "docker", "image": "example.com/names-test:v1"}],
+	"credentials": {"` + name + `": {"env": "CREDENTIAL"}}
+}`
+	}
+	generateArcTemplate := func(options common.BundleDetails) error {
+		_, _, err := GenerateArcTemplate(options)
+		return err
+	}
+	keyVaultBundle := func(name string) string {
+		return `{
+	"name": "names-test",
+	"invocationImages": [{"imageType": "docker", "image": "example.com/names-test:v1"}],
+	"definitions": {"string": {"type": "string", "default": "value"}, "secret": {"type": "string", "writeOnly": true}},
+	"outputs": {"password": {"definition": "secret"}},
+	"parameters": {"` + name + `": {"definition": "string"}}
+}`
+	}
+	generateTemplate := func(options common.BundleDetails) error {
+		_, _, err := GenerateTemplate(options)
+		return err
+	}
+	generateKeyVaultTemplate := func(options common.BundleDetails) error {
+		options.KeyVaultOutputs = true
+		_, _, err := GenerateTemplate(options)
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		bundle   string
+		generate func(common.BundleDetails) error
+		expected string
+	}{
+		{"template action parameter", parameterBundle("action"), generateTemplate, "Invalid Parameter name: action."},
+		{"template allow_bundle_change parameter", parameterBundle("allow_bundle_change"), generateTemplate, "Invalid Parameter name: allow_bundle_change."},
+		{"template action credential", credentialBundle("action"), generateTemplate, "Invalid Credential name: action."},
+		{"arc action parameter", parameterBundle("action"), generateArcTemplate, "Invalid Parameter name: action."},
+		{"arc action credential", credentialBundle("action"), generateArcTemplate, "Invalid Credential name: action."},
+		{"template bundle parameter", parameterBundle("actions"), generateTemplate, ""},
+		{"key vault keyvault_name parameter", keyVaultBundle("keyvault_name"), generateKeyVaultTemplate, "Invalid Parameter name: keyvault_name."},
+		// The key vault is only added to the template if sensitive outputs are stored in it
+		{"template keyvault_name parameter", keyVaultBundle("keyvault_name"), generateTemplate, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, cleanup := writeTestBundle(t, test.bundle)
+			defer cleanup()
+
+			err := test.generate(options)
+			if test.expected == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, test.expected)
+			assert.Equal(t, helpers.ErrorKindInvalidBundle, helpers.KindOf(err))
+		})
+	}
+}
@@ -34,23 +34,28 @@ func arcHandler(w http.ResponseWriter, r *http.Request) {
 	options := common.BundleDetails{
 		BundleLoc: "",
 		Options: common.Options{
-			Indent:            true,
-			OutputWriter:      w,
-			Simplify:          bundleContext.Simplyfy,
-			ReplaceKubeconfig: bundleContext.ReplaceKubeconfig,
-			BundlePullOptions: &opts,
-			Timeout:           bundleContext.Timeout,
-			Debug:             bundleContext.Debug,
-			Dogfood:           bundleContext.Dogfood,
+			Indent:               true,
+			OutputWriter:         w,
+			Simplify:             bundleContext.Simplyfy,
+			ReplaceKubeconfig:    bundleContext.ReplaceKubeconfig,
+			BundlePullOptions:    &opts,
+			RegistryRefreshToken: bundleContext.RegistryRefreshToken,
+			Logger:               common.GetLogger(r.Context()),
+			Timeout:              bundleContext.Timeout,
+			Debug:                bundleContext.Debug,
+			CloudProfile:         bundleContext.CloudProfile,
+			PinDigest:            bundleContext.PinDigest,
 		},
 	}
 	generatedTemplate, _, err := generator.GenerateArcTemplate(options)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to generate Arc template for image: %s error: %v", bundleContext.Ref, err)))
+		common.RecordGeneratorFailure("arc", err)
+		_ = render.Render(w, r, helpers.ErrorFromError(fmt.Errorf("Failed to generate Arc template for image: %s error: %w", bundleContext.Ref, err)))
+		return
 	}
 	err = common.WriteOutput(w, generatedTemplate, options.Indent)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to write Arc template to response for image: %s error: %v", bundleContext.Ref, err)))
+		_ = render.Render(w, r, helpers.ErrorInternalServerErrorFromError(fmt.Errorf("Failed to write Arc template to response for image: %s error: %v", bundleContext.Ref, err)))
 	}
 
 }
@@ -30,14 +30,15 @@ func bundleHandler(w http.ResponseWriter, r *http.Request) {
 		Tag:              bundleContext.Ref,
 	}
 
-	bundle, _, err := common.PullBundle(&opts)
+	bundle, _, err := common.PullBundle(&opts, bundleContext.RegistryRefreshToken, common.GetLogger(r.Context()))
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to get bundle.json for image: %s error: %v", bundleContext.Ref, err)))
+		_ = render.Render(w, r, helpers.ErrorFromError(fmt.Errorf("Failed to get bundle.json for image: %s error: %w", bundleContext.Ref, err)))
+		return
 	}
 
 	err = common.WriteOutput(w, bundle, true)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to write bundle.json to response for image: %s error: %v", bundleContext.Ref, err)))
+		_ = render.Render(w, r, helpers.ErrorInternalServerErrorFromError(fmt.Errorf("Failed to write bundle.json to response for image: %s error: %v", bundleContext.Ref, err)))
 	}
 
 }
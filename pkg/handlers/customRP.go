@@ -40,16 +40,22 @@ func getCustomRPHandler(w http.ResponseWriter, r *http.Request) {
 			Simplify:              bundle.Simplyfy,
 			ReplaceKubeconfig:     bundle.ReplaceKubeconfig,
 			BundlePullOptions:     &opts,
+			RegistryRefreshToken:  bundle.RegistryRefreshToken,
+			Logger:                common.GetLogger(r.Context()),
 			Timeout:               bundle.Timeout,
+			CloudEnvironment:      bundle.CloudEnvironment,
 			IncludeCustomResource: bundle.IncludeCustomResource,
+			PinDigest:             bundle.PinDigest,
 		},
 	}
 	generatedCustomRPTemplate, _, err := generator.GenerateCustomRP(options)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to generate custom RP template for image: %s error: %v", bundle.Ref, err)))
+		common.RecordGeneratorFailure("customrp", err)
+		_ = render.Render(w, r, helpers.ErrorFromError(fmt.Errorf("Failed to generate custom RP template for image: %s error: %w", bundle.Ref, err)))
+		return
 	}
 	err = common.WriteOutput(w, generatedCustomRPTemplate, options.Indent)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to write custom RP template to response for image: %s error: %v", bundle.Ref, err)))
+		_ = render.Render(w, r, helpers.ErrorInternalServerErrorFromError(fmt.Errorf("Failed to write custom RP template to response for image: %s error: %v", bundle.Ref, err)))
 	}
 }
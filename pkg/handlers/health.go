@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg"
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/common"
+)
+
+const (
+	healthStatusOK       = "ok"
+	healthStatusNotReady = "not ready"
+	// registryCheckTimeout is the maximum time that the readiness check waits for the canary registry to respond
+	registryCheckTimeout = 5 * time.Second
+)
+
+// HealthStatus is the response of the health and readiness endpoints
+type HealthStatus struct {
+	Status  string            `json:"status"`
+	Version string            `json:"version"`
+	Commit  string            `json:"commit"`
+	Checks  map[string]string `json:"checks,omitempty"`
+}
+
+// NewHealthHandler is the router for liveness requests
+func NewHealthHandler() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/", healthHandler)
+	return r
+}
+
+// NewReadinessHandler is the router for readiness requests, if canaryRegistry is set the listener is only ready if the registry is reachable
+func NewReadinessHandler(canaryRegistry string) chi.Router {
+	r := chi.NewRouter()
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		readinessHandler(w, r, canaryRegistry)
+	})
+	return r
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, newHealthStatus(healthStatusOK))
+}
+
+func readinessHandler(w http.ResponseWriter, r *http.Request, canaryRegistry string) {
+	status := newHealthStatus(healthStatusOK)
+	status.Checks = make(map[string]string)
+
+	status.Checks["registryConfig"] = healthStatusOK
+	if err := common.CheckRegistryConfig(); err != nil {
+		status.Status = healthStatusNotReady
+		status.Checks["registryConfig"] = err.Error()
+	}
+
+	if len(canaryRegistry) > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), registryCheckTimeout)
+		defer cancel()
+		status.Checks["canaryRegistry"] = healthStatusOK
+		if err := common.CheckRegistryReachable(ctx, canaryRegistry); err != nil {
+			status.Status = healthStatusNotReady
+			status.Checks["canaryRegistry"] = err.Error()
+		}
+	}
+
+	if status.Status != healthStatusOK {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, status)
+}
+
+func newHealthStatus(status string) *HealthStatus {
+	return &HealthStatus{
+		Status:  status,
+		Version: pkg.Version,
+		Commit:  pkg.Commit,
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/common"
+	"gotest.tools/assert"
+)
+
+// getHealthStatus calls handler and returns the status code and the decoded response
+func getHealthStatus(t *testing.T, handler http.HandlerFunc) (int, HealthStatus) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var status HealthStatus
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	return w.Code, status
+}
+
+// newTestRegistry starts a TLS server that responds to registry API requests with statusCode and returns its host,
+// the default HTTP client is replaced by one that trusts the server until the returned function is called
+func newTestRegistry(statusCode int) (string, func()) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statusCode)
+	}))
+	defaultClient := http.DefaultClient
+	http.DefaultClient = server.Client()
+	serverURL, _ := url.Parse(server.URL)
+	return serverURL.Host, func() {
+		http.DefaultClient = defaultClient
+		server.Close()
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	code, status := getHealthStatus(t, healthHandler)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthStatusOK, status.Status)
+	assert.Assert(t, status.Checks == nil)
+}
+
+func TestReadinessHandlerChecksCanaryRegistry(t *testing.T) {
+	registry, closeRegistry := newTestRegistry(http.StatusUnauthorized)
+	code, status := getHealthStatus(t, func(w http.ResponseWriter, r *http.Request) {
+		readinessHandler(w, r, registry)
+	})
+	closeRegistry()
+	assert.Equal(t, http.StatusOK, code)
+	assert.DeepEqual(t, map[string]string{"registryConfig": healthStatusOK, "canaryRegistry": healthStatusOK}, status.Checks)
+
+	registry, closeRegistry = newTestRegistry(http.StatusServiceUnavailable)
+	code, status = getHealthStatus(t, func(w http.ResponseWriter, r *http.Request) {
+		readinessHandler(w, r, registry)
+	})
+	closeRegistry()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, healthStatusNotReady, status.Status)
+	assert.Equal(t, healthStatusOK, status.Checks["registryConfig"])
+	assert.Equal(t, "Registry "+registry+" returned status 503 Service Unavailable", status.Checks["canaryRegistry"])
+
+	// The registry is no longer listening
+	code, status = getHealthStatus(t, func(w http.ResponseWriter, r *http.Request) {
+		readinessHandler(w, r, registry)
+	})
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Assert(t, status.Checks["canaryRegistry"] != healthStatusOK)
+}
+
+func TestReadinessHandlerChecksRegistryConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registryconfig")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	configFileName := filepath.Join(dir, "config.json")
+	assert.NilError(t, ioutil.WriteFile(configFileName, []byte("{}"), 0600))
+	assert.NilError(t, common.SetRegistryConfigFile(configFileName))
+	defer common.SetRegistryConfigFile("")
+
+	code, status := getHealthStatus(t, func(w http.ResponseWriter, r *http.Request) {
+		readinessHandler(w, r, "")
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.DeepEqual(t, map[string]string{"registryConfig": healthStatusOK}, status.Checks)
+
+	assert.NilError(t, os.Remove(configFileName))
+	code, status = getHealthStatus(t, func(w http.ResponseWriter, r *http.Request) {
+		readinessHandler(w, r, "")
+	})
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, healthStatusNotReady, status.Status)
+	assert.Assert(t, strings.HasPrefix(status.Checks["registryConfig"], "Unable to open registry config file"), status.Checks["registryConfig"])
+}
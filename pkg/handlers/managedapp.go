@@ -63,16 +63,21 @@ func managedAppHandler(w http.ResponseWriter, r *http.Request) {
 			Simplify:              bundle.Simplyfy,
 			ReplaceKubeconfig:     bundle.ReplaceKubeconfig,
 			BundlePullOptions:     &opts,
+			RegistryRefreshToken:  bundle.RegistryRefreshToken,
+			Logger:                common.GetLogger(r.Context()),
 			Timeout:               bundle.Timeout,
+			CloudEnvironment:      bundle.CloudEnvironment,
 			IncludeCustomResource: true,
 			CustomRPTemplate:      true,
 			GenerateUI:            true,
+			PinDigest:             bundle.PinDigest,
 		},
 	}
 
 	generatedTemplate, bundledef, err := generator.GenerateCustomRP(options)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInternalServerErrorFromError(fmt.Errorf("Error generating customRP template: %w", err)))
+		common.RecordGeneratorFailure("managedapp", err)
+		_ = render.Render(w, r, helpers.ErrorFromError(fmt.Errorf("Error generating customRP template: %w", err)))
 		return
 	}
 
@@ -88,7 +93,7 @@ func managedAppHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ui, err := uidefinition.NewCreateUIDefinition(bundledef.Name, bundledef.Description, generatedTemplate, options.Simplify, options.ReplaceKubeconfig, bundledef.Custom, options.CustomRPTemplate, options.IncludeCustomResource, options.ArcTemplate, options.Dogfood)
+	ui, err := uidefinition.NewCreateUIDefinition(bundledef.Name, bundledef.Description, generatedTemplate, options.Simplify, options.ReplaceKubeconfig, bundledef.Custom, options.CustomRPTemplate, options.IncludeCustomResource, options.ArcTemplate, options.CloudProfile)
 	if err != nil {
 		_ = render.Render(w, r, helpers.ErrorInternalServerErrorFromError(fmt.Errorf("Failed to generate UI definition, %w", err)))
 		return
@@ -140,21 +145,25 @@ func managedAppDefinitionHandler(w http.ResponseWriter, r *http.Request) {
 	options := common.BundleDetails{
 		BundleLoc: "",
 		Options: common.Options{
-			Indent:            true,
-			OutputWriter:      w,
-			Simplify:          bundle.Simplyfy,
-			ReplaceKubeconfig: bundle.ReplaceKubeconfig,
-			BundlePullOptions: &opts,
-			Timeout:           bundle.Timeout,
+			Indent:               true,
+			OutputWriter:         w,
+			Simplify:             bundle.Simplyfy,
+			ReplaceKubeconfig:    bundle.ReplaceKubeconfig,
+			BundlePullOptions:    &opts,
+			RegistryRefreshToken: bundle.RegistryRefreshToken,
+			Logger:               common.GetLogger(r.Context()),
+			Timeout:              bundle.Timeout,
 		},
 	}
 	generatedTemplate, _, err := generator.GenerateManagedAppDefinitionTemplate(options, packageUri)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to generate managed app definition template for image: %s error: %v", bundle.Ref, err)))
+		common.RecordGeneratorFailure("appdefinition", err)
+		_ = render.Render(w, r, helpers.ErrorFromError(fmt.Errorf("Failed to generate managed app definition template for image: %s error: %w", bundle.Ref, err)))
+		return
 	}
 	err = common.WriteOutput(w, generatedTemplate, options.Indent)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to write  managed app definition template to response for image: %s error: %v", bundle.Ref, err)))
+		_ = render.Render(w, r, helpers.ErrorInternalServerErrorFromError(fmt.Errorf("Failed to write  managed app definition template to response for image: %s error: %v", bundle.Ref, err)))
 	}
 
 }
@@ -50,16 +50,24 @@ func solutionTemplateHandler(w http.ResponseWriter, r *http.Request) {
 			Simplify:              true,
 			ReplaceKubeconfig:     true,
 			BundlePullOptions:     &opts,
+			RegistryRefreshToken:  bundle.RegistryRefreshToken,
+			Logger:                common.GetLogger(r.Context()),
 			Timeout:               bundle.Timeout,
 			IncludeCustomResource: false,
 			CustomRPTemplate:      false,
 			GenerateUI:            true,
+			KeyVaultOutputs:       bundle.KeyVaultOutputs,
+			CloudEnvironment:      bundle.CloudEnvironment,
+			ToolVersions:          bundle.ToolVersions,
+			ToolsMirrorURL:        bundle.ToolsMirrorURL,
+			PinDigest:             bundle.PinDigest,
 		},
 	}
 
 	generatedTemplate, bundledef, err := generator.GenerateTemplate(options)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInternalServerErrorFromError(fmt.Errorf("Error generating solution template ARM template: %w", err)))
+		common.RecordGeneratorFailure("solutiontemplate", err)
+		_ = render.Render(w, r, helpers.ErrorFromError(fmt.Errorf("Error generating solution template ARM template: %w", err)))
 		return
 	}
 
@@ -75,7 +83,7 @@ func solutionTemplateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ui, err := uidefinition.NewCreateUIDefinition(bundledef.Name, bundledef.Description, generatedTemplate, options.Simplify, options.ReplaceKubeconfig, bundledef.Custom, options.CustomRPTemplate, options.IncludeCustomResource, options.ArcTemplate, options.Dogfood)
+	ui, err := uidefinition.NewCreateUIDefinition(bundledef.Name, bundledef.Description, generatedTemplate, options.Simplify, options.ReplaceKubeconfig, bundledef.Custom, options.CustomRPTemplate, options.IncludeCustomResource, options.ArcTemplate, options.CloudProfile)
 	if err != nil {
 		_ = render.Render(w, r, helpers.ErrorInternalServerErrorFromError(fmt.Errorf("Failed to generate UI definition, %w", err)))
 		return
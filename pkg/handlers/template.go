@@ -13,7 +13,6 @@ import (
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/generator"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/helpers"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/models"
-	log "github.com/sirupsen/logrus"
 )
 
 // NewTemplateHandler is the router for Template generation requests
@@ -54,22 +53,35 @@ func templateHandler(w http.ResponseWriter, r *http.Request) {
 	options := common.BundleDetails{
 		BundleLoc: "",
 		Options: common.Options{
-			Indent:            true,
-			OutputWriter:      w,
-			Simplify:          bundle.Simplyfy,
-			ReplaceKubeconfig: bundle.ReplaceKubeconfig,
-			BundlePullOptions: &opts,
-			Timeout:           bundle.Timeout,
-			Debug:             bundle.Debug,
+			Indent:               true,
+			OutputWriter:         w,
+			Simplify:             bundle.Simplyfy,
+			ReplaceKubeconfig:    bundle.ReplaceKubeconfig,
+			BundlePullOptions:    &opts,
+			RegistryRefreshToken: bundle.RegistryRefreshToken,
+			Logger:               common.GetLogger(r.Context()),
+			Timeout:              bundle.Timeout,
+			Debug:                bundle.Debug,
+			Format:               bundle.Format,
+			KeyVaultOutputs:      bundle.KeyVaultOutputs,
+			CloudEnvironment:     bundle.CloudEnvironment,
+			ToolVersions:         bundle.ToolVersions,
+			ToolsMirrorURL:       bundle.ToolsMirrorURL,
+			PinDigest:            bundle.PinDigest,
 		},
 	}
 	generatedTemplate, _, err := generator.GenerateTemplate(options)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to generate template for image: %s error: %v", bundle.Ref, err)))
+		common.RecordGeneratorFailure("template", err)
+		_ = render.Render(w, r, helpers.ErrorFromError(fmt.Errorf("Failed to generate template for image: %s error: %w", bundle.Ref, err)))
+		return
 	}
-	err = common.WriteOutput(w, generatedTemplate, options.Indent)
+	if options.Format == common.OutputFormatBicep {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	err = generator.WriteTemplate(w, generatedTemplate, options.Options)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to write template to response for image: %s error: %v", bundle.Ref, err)))
+		_ = render.Render(w, r, helpers.ErrorInternalServerErrorFromError(fmt.Errorf("Failed to write template to response for image: %s error: %v", bundle.Ref, err)))
 	}
 
 }
@@ -86,11 +98,13 @@ func nestedDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 
 	options := generator.GenerateNestedDeploymentOptions{
 		Options: common.Options{
-			Indent:            true,
-			OutputWriter:      w,
-			Simplify:          bundle.Simplyfy,
-			ReplaceKubeconfig: bundle.ReplaceKubeconfig,
-			BundlePullOptions: &opts,
+			Indent:               true,
+			OutputWriter:         w,
+			Simplify:             bundle.Simplyfy,
+			ReplaceKubeconfig:    bundle.ReplaceKubeconfig,
+			BundlePullOptions:    &opts,
+			RegistryRefreshToken: bundle.RegistryRefreshToken,
+			Logger:               common.GetLogger(r.Context()),
 		},
 	}
 
@@ -98,7 +112,8 @@ func nestedDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := generator.GenerateNestedDeployment(options)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to generate nested deployment for image: %s error: %v", bundle.Ref, err)))
+		common.RecordGeneratorFailure("deployment", err)
+		_ = render.Render(w, r, helpers.ErrorFromError(fmt.Errorf("Failed to generate nested deployment for image: %s error: %w", bundle.Ref, err)))
 	}
 }
 
@@ -106,11 +121,7 @@ func redirectHandler(w http.ResponseWriter, r *http.Request) {
 	originalRequestUri := r.Context().Value(common.RequestURIContext).(string)
 	bundle := r.Context().Value(models.BundleContext).(*models.Bundle)
 	templateUri := strings.Replace(originalRequestUri, models.RedirectPath, models.TemplateGeneratorPath, 1)
-	portalURL := "portal.azure.com"
-	if bundle.Dogfood {
-		portalURL = "df.onecloud.azure-test.net"
-	}
-	redirectURI := fmt.Sprintf("https://%s/#create/Microsoft.Template/uri/%s", portalURL, url.PathEscape(templateUri))
-	log.Infof("Redirecting %s to %s", originalRequestUri, redirectURI)
+	redirectURI := fmt.Sprintf("%s/#create/Microsoft.Template/uri/%s", common.GetPortalURL(bundle.CloudEnvironment, bundle.CloudProfile), url.PathEscape(templateUri))
+	common.GetLogger(r.Context()).Infof("Redirecting %s to %s", originalRequestUri, redirectURI)
 	http.Redirect(w, r, redirectURI, http.StatusTemporaryRedirect)
 }
@@ -17,7 +17,6 @@ import (
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/models"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/template"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/uidefinition"
-	log "github.com/sirupsen/logrus"
 )
 
 // NewUIHandler is the router for UI definition generation requests
@@ -56,10 +55,17 @@ func uiHandler(w http.ResponseWriter, r *http.Request) {
 			GenerateUI:            true,
 			UIWriter:              w,
 			BundlePullOptions:     &opts,
+			RegistryRefreshToken:  bundle.RegistryRefreshToken,
+			Logger:                common.GetLogger(r.Context()),
 			Timeout:               bundle.Timeout,
 			IncludeCustomResource: bundle.IncludeCustomResource,
 			CustomRPTemplate:      bundle.CustomRPTemplate,
-			Dogfood:               bundle.Dogfood,
+			CloudProfile:          bundle.CloudProfile,
+			CloudEnvironment:      bundle.CloudEnvironment,
+			KeyVaultOutputs:       bundle.KeyVaultOutputs,
+			ToolVersions:          bundle.ToolVersions,
+			ToolsMirrorURL:        bundle.ToolsMirrorURL,
+			PinDigest:             bundle.PinDigest,
 		},
 	}
 
@@ -78,15 +84,19 @@ func uiHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to generate template for image: %s error: %v", bundle.Ref, err)))
+		common.RecordGeneratorFailure("uidefinition", err)
+		_ = render.Render(w, r, helpers.ErrorFromError(fmt.Errorf("Failed to generate template for image: %s error: %w", bundle.Ref, err)))
+		return
 	}
-	ui, err := uidefinition.NewCreateUIDefinition(bundledef.Name, bundledef.Description, generatedTemplate, options.Simplify, options.ReplaceKubeconfig, bundledef.Custom, bundle.CustomRPTemplate, bundle.IncludeCustomResource, bundle.ArcTemplate, bundle.Dogfood)
+	ui, err := uidefinition.NewCreateUIDefinition(bundledef.Name, bundledef.Description, generatedTemplate, options.Simplify, options.ReplaceKubeconfig, bundledef.Custom, bundle.CustomRPTemplate, bundle.IncludeCustomResource, bundle.ArcTemplate, bundle.CloudProfile)
 	if err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to generate UI Def for image: %s error: %v", bundle.Ref, err)))
+		common.RecordGeneratorFailure("uidefinition", err)
+		_ = render.Render(w, r, helpers.ErrorFromError(fmt.Errorf("Failed to generate UI Def for image: %s error: %w", bundle.Ref, err)))
+		return
 	}
 
 	if err := common.WriteOutput(options.UIWriter, ui, options.Indent); err != nil {
-		_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to Write UI output for image: %s error: %v", bundle.Ref, err)))
+		_ = render.Render(w, r, helpers.ErrorInternalServerErrorFromError(fmt.Errorf("Failed to Write UI output for image: %s error: %v", bundle.Ref, err)))
 	}
 }
 
@@ -99,11 +109,7 @@ func uiRedirectHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	templateUri := strings.Replace(originalRequestUri, models.UIRedirectPath, templateGeneratorPath, 1)
 	uiURI := strings.Replace(originalRequestUri, models.UIRedirectPath, models.UIDefPath, 1)
-	portalURL := "portal.azure.com"
-	if bundle.Dogfood {
-		portalURL = "df.onecloud.azure-test.net"
-	}
-	redirectURI := fmt.Sprintf("https://%s/#create/Microsoft.Template/uri/%s/createUIDefinitionUri/%s", portalURL, url.PathEscape(templateUri), url.PathEscape(uiURI))
-	log.Infof("Redirecting %s to %s", originalRequestUri, redirectURI)
+	redirectURI := fmt.Sprintf("%s/#create/Microsoft.Template/uri/%s/createUIDefinitionUri/%s", common.GetPortalURL(bundle.CloudEnvironment, bundle.CloudProfile), url.PathEscape(templateUri), url.PathEscape(uiURI))
+	common.GetLogger(r.Context()).Infof("Redirecting %s to %s", originalRequestUri, redirectURI)
 	http.Redirect(w, r, redirectURI, http.StatusTemporaryRedirect)
 }
@@ -1,8 +1,10 @@
 package helpers
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
 )
 
@@ -10,12 +12,15 @@ type RequestError struct {
 	HTTPStatusCode int    `json:"-"`
 	Status         string `json:"status"`
 	Message        string `json:"error"`
+	RequestID      string `json:"requestId,omitempty"`
 }
 type ErrorResponse struct {
 	*RequestError `json:"ErrorResponse"`
 }
 
+// Render sets the status code of the response and the ID of the request so that the error can be correlated with the log entries for the request
 func (e *ErrorResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	e.RequestID = middleware.GetReqID(r.Context())
 	render.Status(r, e.HTTPStatusCode)
 	return nil
 }
@@ -49,3 +54,105 @@ func ErrorInternalServerErrorFromError(err error) *ErrorResponse {
 		},
 	}
 }
+
+// ErrorKind classifies an error so that it is returned with the appropriate HTTP status code
+type ErrorKind int
+
+const (
+	// ErrorKindInternal is an unexpected error
+	ErrorKindInternal ErrorKind = iota
+	// ErrorKindInvalidRequest is an error caused by invalid request parameters
+	ErrorKindInvalidRequest
+	// ErrorKindNotFound is an error caused by a bundle that does not exist
+	ErrorKindNotFound
+	// ErrorKindUnauthorized is an error caused by the registry rejecting the credentials used to pull a bundle
+	ErrorKindUnauthorized
+	// ErrorKindForbidden is an error caused by the registry denying access to a bundle
+	ErrorKindForbidden
+	// ErrorKindRegistryUnavailable is an error caused by a registry that cannot be reached or that failed
+	ErrorKindRegistryUnavailable
+	// ErrorKindInvalidBundle is an error caused by a bundle that cannot be converted
+	ErrorKindInvalidBundle
+)
+
+var errorKindStatusCodes = map[ErrorKind]int{
+	ErrorKindInternal:            http.StatusInternalServerError,
+	ErrorKindInvalidRequest:      http.StatusBadRequest,
+	ErrorKindNotFound:            http.StatusNotFound,
+	ErrorKindUnauthorized:        http.StatusUnauthorized,
+	ErrorKindForbidden:           http.StatusForbidden,
+	ErrorKindRegistryUnavailable: http.StatusBadGateway,
+	ErrorKindInvalidBundle:       http.StatusUnprocessableEntity,
+}
+
+var errorKindNames = map[ErrorKind]string{
+	ErrorKindInternal:            "internal",
+	ErrorKindInvalidRequest:      "invalid_request",
+	ErrorKindNotFound:            "not_found",
+	ErrorKindUnauthorized:        "unauthorized",
+	ErrorKindForbidden:           "forbidden",
+	ErrorKindRegistryUnavailable: "registry_unavailable",
+	ErrorKindInvalidBundle:       "invalid_bundle",
+}
+
+// StatusCode returns the HTTP status code of the kind of error
+func (kind ErrorKind) StatusCode() int {
+	if statusCode, ok := errorKindStatusCodes[kind]; ok {
+		return statusCode
+	}
+	return http.StatusInternalServerError
+}
+
+// String returns the name of the kind of error
+func (kind ErrorKind) String() string {
+	if name, ok := errorKindNames[kind]; ok {
+		return name
+	}
+	return errorKindNames[ErrorKindInternal]
+}
+
+// Error is an error that has been classified
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError classifies err as kind, it returns nil if err is nil
+func NewError(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+// KindOf returns the kind of the first classified error in the chain of err, errors that have not been classified are internal errors
+func KindOf(err error) ErrorKind {
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.Kind
+	}
+	return ErrorKindInternal
+}
+
+// ErrorFromError returns an error response with the HTTP status code of the kind of err
+func ErrorFromError(err error) render.Renderer {
+	statusCode := KindOf(err).StatusCode()
+	return &ErrorResponse{
+		&RequestError{
+			HTTPStatusCode: statusCode,
+			Status:         http.StatusText(statusCode),
+			Message:        err.Error(),
+		},
+	}
+}
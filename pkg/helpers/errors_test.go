@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestKindOf(t *testing.T) {
+	assert.Equal(t, ErrorKindInternal, KindOf(errors.New("unclassified")))
+	assert.Equal(t, ErrorKindInternal, KindOf(nil))
+
+	err := NewError(ErrorKindNotFound, errors.New("Bundle does not exist"))
+	assert.Equal(t, ErrorKindNotFound, KindOf(err))
+	assert.Equal(t, ErrorKindNotFound, KindOf(fmt.Errorf("Failed to pull bundle: %w", err)))
+	assert.Equal(t, "Bundle does not exist", err.Error())
+
+	assert.NilError(t, NewError(ErrorKindInvalidRequest, nil))
+}
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		kind       ErrorKind
+		statusCode int
+		name       string
+	}{
+		{ErrorKindInternal, http.StatusInternalServerError, "internal"},
+		{ErrorKindInvalidRequest, http.StatusBadRequest, "invalid_request"},
+		{ErrorKindNotFound, http.StatusNotFound, "not_found"},
+		{ErrorKindUnauthorized, http.StatusUnauthorized, "unauthorized"},
+		{ErrorKindForbidden, http.StatusForbidden, "forbidden"},
+		{ErrorKindRegistryUnavailable, http.StatusBadGateway, "registry_unavailable"},
+		{ErrorKindInvalidBundle, http.StatusUnprocessableEntity, "invalid_bundle"},
+		{ErrorKind(100), http.StatusInternalServerError, "internal"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.statusCode, test.kind.StatusCode())
+		assert.Equal(t, test.name, test.kind.String())
+	}
+}
+
+func TestErrorFromError(t *testing.T) {
+	err := fmt.Errorf("Failed to generate template: %w", NewError(ErrorKindInvalidRequest, errors.New("Cloud profile test does not exist")))
+
+	response := ErrorFromError(err).(*ErrorResponse)
+	assert.Equal(t, http.StatusBadRequest, response.HTTPStatusCode)
+	assert.Equal(t, "Bad Request", response.Status)
+	assert.Equal(t, err.Error(), response.Message)
+
+	response = ErrorFromError(errors.New("unclassified")).(*ErrorResponse)
+	assert.Equal(t, http.StatusInternalServerError, response.HTTPStatusCode)
+}
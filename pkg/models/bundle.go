@@ -29,6 +29,9 @@ const (
 	SolutionTemplatePath        string           = "/api/solutiontemplate"
 	ManagedAppDefinitionPath    string           = "/api/appdefinition"
 	ArcTemplatePath             string           = "/api/arc"
+	MetricsPath                 string           = "/metrics"
+	HealthPath                  string           = "/healthz"
+	ReadinessPath               string           = "/readyz"
 	BundleContext               BundleContextKey = "bundle"
 )
 
@@ -43,7 +46,14 @@ type Bundle struct {
 	CustomRPTemplate      bool
 	ArcTemplate           bool
 	Debug                 bool
-	Dogfood               bool
+	CloudProfile          *common.CloudProfile
+	CloudEnvironment      *common.CloudEnvironment
+	Format                string
+	KeyVaultOutputs       bool
+	ToolVersions          common.ToolVersions
+	ToolsMirrorURL        string
+	PinDigest             bool
+	RegistryRefreshToken  string
 }
 
 func BundleCtx(next http.Handler) http.Handler {
@@ -78,6 +88,49 @@ func BundleCtx(next http.Handler) http.Handler {
 			_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(fmt.Errorf("Failed to parse image reference: %s error: %v", imageName, err)))
 			return
 		}
+		format := getStringQueryParam(r, "format", common.OutputFormatJSON)
+		if err := common.ValidateFormat(format); err != nil {
+			_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(err))
+			return
+		}
+
+		// dogfood is retained as an alias for the dogfood cloud profile
+		profileName := getStringQueryParam(r, "profile", common.DefaultCloudProfileName)
+		if getBoolQueryParam(r, "dogfood") {
+			profileName = common.DogfoodCloudProfileName
+		}
+		profile, err := common.GetCloudProfile(profileName)
+		if err != nil {
+			_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(err))
+			return
+		}
+
+		cloud, err := common.GetCloudEnvironment(getStringQueryParam(r, "cloud", common.AzureCloudName))
+		if err != nil {
+			_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(err))
+			return
+		}
+
+		toolVersions := common.ToolVersions{
+			PorterVersion:       getStringQueryParam(r, "porterversion", ""),
+			PorterChecksum:      getStringQueryParam(r, "porterchecksum", ""),
+			AzurePluginVersion:  getStringQueryParam(r, "pluginversion", ""),
+			AzurePluginChecksum: getStringQueryParam(r, "pluginchecksum", ""),
+			AzureDriverVersion:  getStringQueryParam(r, "driverversion", ""),
+			AzureDriverChecksum: getStringQueryParam(r, "driverchecksum", ""),
+		}
+		if err := toolVersions.Validate(); err != nil {
+			_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(err))
+			return
+		}
+
+		// URLs are case sensitive so the mirror URL is not converted to lower case
+		toolsMirrorURL := getQueryParam(r, "mirrorurl", "")
+		if err := common.ValidateMirrorURL(toolsMirrorURL); err != nil {
+			_ = render.Render(w, r, helpers.ErrorInvalidRequestFromError(err))
+			return
+		}
+
 		bundleContext := Bundle{
 			Ref:                   imageName,
 			Force:                 getBoolQueryParam(r, "force"),
@@ -87,16 +140,59 @@ func BundleCtx(next http.Handler) http.Handler {
 			ReplaceKubeconfig:     getBoolQueryParam(r, "useaks"),
 			IncludeCustomResource: getBoolQueryParam(r, "includeresource"),
 			Debug:                 getBoolQueryParam(r, "debug"),
-			Dogfood:               getBoolQueryParam(r, "dogfood"),
+			CloudProfile:          profile,
+			CloudEnvironment:      cloud,
 			CustomRPTemplate:      customRpTemplate,
 			ArcTemplate:           getBoolQueryParam(r, "arc"),
+			Format:                format,
+			KeyVaultOutputs:       getBoolQueryParam(r, "keyvaultoutputs"),
+			ToolVersions:          toolVersions,
+			ToolsMirrorURL:        toolsMirrorURL,
+			PinDigest:             getBoolQueryParam(r, "pindigest"),
+			RegistryRefreshToken:  getBearerToken(r),
 		}
 
+		common.AddLogFields(r.Context(), log.Fields{
+			"endpoint": getEndpoint(r.URL.Path),
+			"bundle":   imageName,
+		})
+
 		ctx := context.WithValue(r.Context(), BundleContext, &bundleContext)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// getEndpoint returns the path of the endpoint that a request path is for
+func getEndpoint(path string) string {
+	for _, endpoint := range []string{
+		TemplateGeneratorPath,
+		NestedResourceGeneratorPath,
+		RedirectPath,
+		UIRedirectPath,
+		BundlePath,
+		UIDefPath,
+		CustomRPPath,
+		ManagedAppPath,
+		SolutionTemplatePath,
+		ManagedAppDefinitionPath,
+		ArcTemplatePath,
+	} {
+		if path == endpoint || strings.HasPrefix(path, endpoint+"/") {
+			return endpoint
+		}
+	}
+	return path
+}
+
+// getBearerToken returns the bearer token in the Authorization header of the request, the token is used as a refresh token for the registry when pulling the bundle
+func getBearerToken(r *http.Request) string {
+	authorization := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(authorization) != 2 || !strings.EqualFold(authorization[0], "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(authorization[1])
+}
+
 func getBoolQueryParam(r *http.Request, name string) bool {
 	result := false
 	for k, v := range r.URL.Query() {
@@ -109,6 +205,22 @@ func getBoolQueryParam(r *http.Request, name string) bool {
 	return result
 }
 
+func getStringQueryParam(r *http.Request, name string, defaultValue string) string {
+	return strings.ToLower(getQueryParam(r, name, defaultValue))
+}
+
+func getQueryParam(r *http.Request, name string, defaultValue string) string {
+	result := defaultValue
+	for k, v := range r.URL.Query() {
+		// ignore multiple values
+		if strings.EqualFold(k, name) && (len(v[0]) > 0) {
+			result = v[0]
+			break
+		}
+	}
+	return result
+}
+
 func getIntQueryParam(r *http.Request, name string, defaultValue int) int {
 	result := defaultValue
 	for k, v := range r.URL.Query() {
@@ -118,10 +230,10 @@ func getIntQueryParam(r *http.Request, name string, defaultValue int) int {
 				if err = common.ValidateTimeout(val); err == nil {
 					result = val
 				} else {
-					log.Infof("%s. default value %d used", err, defaultValue)
+					common.GetLogger(r.Context()).Infof("%s. default value %d used", err, defaultValue)
 				}
 			} else {
-				log.Infof("Cannot convert %s to int for param %s, default value %d used", v[0], name, defaultValue)
+				common.GetLogger(r.Context()).Infof("Cannot convert %s to int for param %s, default value %d used", v[0], name, defaultValue)
 			}
 			break
 		}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"net/http"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestGetBearerToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		expected      string
+	}{
+		{"no header", "", ""},
+		{"bearer token", "Bearer token", "token"},
+		{"lower case scheme", "bearer token", "token"},
+		{"surrounding white space", "Bearer  token ", "token"},
+		{"basic credentials", "Basic dXNlcjpwYXNzd29yZA==", ""},
+		{"scheme without a token", "Bearer", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "https://example.com/api/bundle/bundle:v1", nil)
+			assert.NilError(t, err)
+			if len(test.authorization) > 0 {
+				r.Header.Set("Authorization", test.authorization)
+			}
+			assert.Equal(t, test.expected, getBearerToken(r))
+		})
+	}
+}
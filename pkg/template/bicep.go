@@ -0,0 +1,734 @@
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const bicepIndent = "  "
+
+var bicepIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var bicepInvalidIdentifierChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+var bicepKeywords = map[string]bool{
+	"param":       true,
+	"var":         true,
+	"resource":    true,
+	"output":      true,
+	"module":      true,
+	"targetScope": true,
+	"existing":    true,
+	"if":          true,
+	"for":         true,
+	"in":          true,
+	"true":        true,
+	"false":       true,
+	"null":        true,
+	"metadata":    true,
+	"import":      true,
+	"type":        true,
+	"func":        true,
+}
+
+// bicepFunctionNames maps lower case ARM template function names to the casing Bicep expects
+var bicepFunctionNames = map[string]string{
+	"base64":          "base64",
+	"base64tostring":  "base64ToString",
+	"concat":          "concat",
+	"contains":        "contains",
+	"deployment":      "deployment",
+	"empty":           "empty",
+	"environment":     "environment",
+	"first":           "first",
+	"format":          "format",
+	"guid":            "guid",
+	"json":            "json",
+	"last":            "last",
+	"length":          "length",
+	"listkeys":        "listKeys",
+	"newguid":         "newGuid",
+	"reference":       "reference",
+	"replace":         "replace",
+	"resourcegroup":   "resourceGroup",
+	"resourceid":      "resourceId",
+	"split":           "split",
+	"string":          "string",
+	"subscription":    "subscription",
+	"substring":       "substring",
+	"take":            "take",
+	"tolower":         "toLower",
+	"toupper":         "toUpper",
+	"trim":            "trim",
+	"union":           "union",
+	"uniquestring":    "uniqueString",
+	"utcnow":          "utcNow",
+	"base64tojson":    "base64ToJson",
+	"datauritostring": "dataUriToString",
+}
+
+// bicepOperators maps ARM template comparison and arithmetic functions to the equivalent Bicep operator
+var bicepOperators = map[string]string{
+	"equals":          "==",
+	"and":             "&&",
+	"or":              "||",
+	"less":            "<",
+	"lessorequals":    "<=",
+	"greater":         ">",
+	"greaterorequals": ">=",
+	"add":             "+",
+	"sub":             "-",
+	"mul":             "*",
+	"div":             "/",
+	"mod":             "%",
+}
+
+type bicepWriter struct {
+	template   *Template
+	parameters map[string]string
+	variables  map[string]string
+	resources  []string
+	used       map[string]bool
+	builder    strings.Builder
+}
+
+// WriteBicep writes the template to writer as a Bicep file
+func (template *Template) WriteBicep(writer io.Writer) error {
+	bicep, err := template.ToBicep()
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(writer, bicep); err != nil {
+		return fmt.Errorf("Error writing bicep output: %w", err)
+	}
+
+	return nil
+}
+
+// ToBicep converts the template to Bicep
+func (template *Template) ToBicep() (string, error) {
+	b := bicepWriter{
+		template:   template,
+		parameters: make(map[string]string),
+		variables:  make(map[string]string),
+		used:       make(map[string]bool),
+	}
+
+	// Parameters, variables and resources share a single namespace in Bicep so clashing names need to be renamed
+	for _, name := range sortedKeys(template.Parameters) {
+		b.parameters[name] = b.declare(name, "Param")
+	}
+
+	for _, name := range sortedKeys(template.Variables) {
+		b.variables[name] = b.declare(name, "Var")
+	}
+
+	for _, resource := range template.Resources {
+		b.resources = append(b.resources, b.declare(resourceSymbolicName(resource.Type), "Resource"))
+	}
+
+	if err := b.writeMetadata(); err != nil {
+		return "", err
+	}
+
+	if err := b.writeParameters(); err != nil {
+		return "", err
+	}
+
+	if err := b.writeVariables(); err != nil {
+		return "", err
+	}
+
+	if err := b.writeResources(); err != nil {
+		return "", err
+	}
+
+	if err := b.writeOutputs(); err != nil {
+		return "", err
+	}
+
+	// Each section is followed by a blank line, the file should only end with a single newline
+	return strings.TrimRight(b.builder.String(), "\n") + "\n", nil
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]Parameter:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]interface{}:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]Output:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func resourceSymbolicName(resourceType string) string {
+	segments := strings.Split(resourceType, "/")
+	name := segments[len(segments)-1]
+	if len(name) == 0 {
+		return "resource"
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+func bicepSafeIdentifier(name string) string {
+	identifier := bicepInvalidIdentifierChars.ReplaceAllString(name, "_")
+	if len(identifier) == 0 || (identifier[0] >= '0' && identifier[0] <= '9') {
+		identifier = "_" + identifier
+	}
+	return identifier
+}
+
+func (b *bicepWriter) declare(name string, suffix string) string {
+	identifier := bicepSafeIdentifier(name)
+	if bicepKeywords[identifier] || b.used[identifier] {
+		identifier += suffix
+	}
+
+	candidate := identifier
+	for i := 1; b.used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s%d", identifier, i)
+	}
+
+	b.used[candidate] = true
+	return candidate
+}
+
+func (b *bicepWriter) writeLine(indent int, format string, args ...interface{}) {
+	b.builder.WriteString(strings.Repeat(bicepIndent, indent))
+	b.builder.WriteString(fmt.Sprintf(format, args...))
+	b.builder.WriteString("\n")
+}
+
+func (b *bicepWriter) writeMetadata() error {
+	for _, name := range sortedKeys(b.template.Metadata) {
+		value, err := b.value(b.template.Metadata[name], 0)
+		if err != nil {
+			return fmt.Errorf("Failed to convert metadata %s to bicep: %w", name, err)
+		}
+		b.writeLine(0, "metadata %s = %s", bicepSafeIdentifier(name), value)
+	}
+
+	if len(b.template.Metadata) > 0 {
+		b.builder.WriteString("\n")
+	}
+
+	return nil
+}
+
+func (b *bicepWriter) writeParameters() error {
+	for _, name := range sortedKeys(b.template.Parameters) {
+		parameter := b.template.Parameters[name]
+
+		if parameter.Metadata != nil && len(parameter.Metadata.Description) > 0 {
+			b.writeLine(0, "@description(%s)", bicepString(parameter.Metadata.Description))
+		}
+
+		if parameter.AllowedValues != nil {
+			allowedValues, err := b.value(parameter.AllowedValues, 0)
+			if err != nil {
+				return fmt.Errorf("Failed to convert allowed values for parameter %s to bicep: %w", name, err)
+			}
+			b.writeLine(0, "@allowed(%s)", allowedValues)
+		}
+
+		if parameter.MinValue != nil {
+			b.writeLine(0, "@minValue(%d)", *parameter.MinValue)
+		}
+
+		if parameter.MaxValue != nil {
+			b.writeLine(0, "@maxValue(%d)", *parameter.MaxValue)
+		}
+
+		if parameter.MinLength != nil {
+			b.writeLine(0, "@minLength(%d)", *parameter.MinLength)
+		}
+
+		if parameter.MaxLength != nil {
+			b.writeLine(0, "@maxLength(%d)", *parameter.MaxLength)
+		}
+
+		parameterType := strings.ToLower(parameter.Type)
+		switch parameterType {
+		case "securestring":
+			b.writeLine(0, "@secure()")
+			parameterType = "string"
+		case "secureobject":
+			b.writeLine(0, "@secure()")
+			parameterType = "object"
+		}
+
+		declaration := fmt.Sprintf("param %s %s", b.parameters[name], parameterType)
+		if parameter.DefaultValue != nil {
+			defaultValue, err := b.value(parameter.DefaultValue, 0)
+			if err != nil {
+				return fmt.Errorf("Failed to convert default value for parameter %s to bicep: %w", name, err)
+			}
+			declaration = fmt.Sprintf("%s = %s", declaration, defaultValue)
+		}
+
+		b.writeLine(0, "%s", declaration)
+		b.builder.WriteString("\n")
+	}
+
+	return nil
+}
+
+func (b *bicepWriter) writeVariables() error {
+	for _, name := range sortedKeys(b.template.Variables) {
+		value, err := b.value(b.template.Variables[name], 0)
+		if err != nil {
+			return fmt.Errorf("Failed to convert variable %s to bicep: %w", name, err)
+		}
+		b.writeLine(0, "var %s = %s", b.variables[name], value)
+	}
+
+	if len(b.template.Variables) > 0 {
+		b.builder.WriteString("\n")
+	}
+
+	return nil
+}
+
+func (b *bicepWriter) writeResources() error {
+	for i, resource := range b.template.Resources {
+		properties, err := toOrderedObject(resource)
+		if err != nil {
+			return fmt.Errorf("Failed to convert resource %s to bicep: %w", resource.Name, err)
+		}
+
+		declaration := fmt.Sprintf("resource %s '%s@%s' = ", b.resources[i], resource.Type, resource.APIVersion)
+		if len(resource.Condition) > 0 {
+			condition, err := b.value(resource.Condition, 0)
+			if err != nil {
+				return fmt.Errorf("Failed to convert condition for resource %s to bicep: %w", resource.Name, err)
+			}
+			declaration += fmt.Sprintf("if (%s) ", condition)
+		}
+
+		b.writeLine(0, "%s{", declaration)
+
+		for _, property := range properties {
+			switch property.key {
+			case "type", "apiVersion", "condition", "dependsOn":
+				continue
+			}
+			// ARM templates allow an empty location for resources that do not have one
+			if property.value == nil || (property.key == "location" && property.value == "") {
+				continue
+			}
+			value, err := b.value(property.value, 1)
+			if err != nil {
+				return fmt.Errorf("Failed to convert property %s of resource %s to bicep: %w", property.key, resource.Name, err)
+			}
+			b.writeLine(1, "%s: %s", bicepKey(property.key, b), value)
+		}
+
+		if len(resource.DependsOn) > 0 {
+			dependencies, err := b.dependencies(resource.DependsOn)
+			if err != nil {
+				return fmt.Errorf("Failed to convert dependsOn for resource %s to bicep: %w", resource.Name, err)
+			}
+			b.writeLine(1, "dependsOn: [")
+			for _, dependency := range dependencies {
+				b.writeLine(2, "%s", dependency)
+			}
+			b.writeLine(1, "]")
+		}
+
+		b.writeLine(0, "}")
+		b.builder.WriteString("\n")
+	}
+
+	return nil
+}
+
+func (b *bicepWriter) writeOutputs() error {
+	for _, name := range sortedKeys(b.template.Outputs) {
+		output := b.template.Outputs[name]
+		value, err := b.value(output.Value, 0)
+		if err != nil {
+			return fmt.Errorf("Failed to convert output %s to bicep: %w", name, err)
+		}
+		b.writeLine(0, "output %s %s = %s", bicepSafeIdentifier(name), strings.ToLower(output.Type), value)
+	}
+
+	return nil
+}
+
+// dependencies converts ARM dependsOn entries to Bicep symbolic resource names,
+// an entry is matched to a resource by name or by the type passed to resourceId
+func (b *bicepWriter) dependencies(dependsOn []string) ([]string, error) {
+	var dependencies []string
+	seen := make(map[string]bool)
+	add := func(symbol string) {
+		if !seen[symbol] {
+			seen[symbol] = true
+			dependencies = append(dependencies, symbol)
+		}
+	}
+
+	for _, dependency := range dependsOn {
+		found := false
+		for i, resource := range b.template.Resources {
+			if resource.Name == dependency {
+				add(b.resources[i])
+				found = true
+			}
+		}
+
+		if found {
+			continue
+		}
+
+		if resourceType := resourceIdType(dependency); len(resourceType) > 0 {
+			for i, resource := range b.template.Resources {
+				if strings.EqualFold(resource.Type, resourceType) {
+					add(b.resources[i])
+					found = true
+				}
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("Unable to find resource for dependency %s", dependency)
+		}
+	}
+
+	return dependencies, nil
+}
+
+func resourceIdType(dependency string) string {
+	if !isARMExpression(dependency) {
+		return ""
+	}
+
+	expression, err := parseARMExpression(dependency[1 : len(dependency)-1])
+	if err != nil {
+		return ""
+	}
+
+	call, ok := expression.(*armCall)
+	if !ok || !strings.EqualFold(call.name, "resourceId") {
+		return ""
+	}
+
+	for _, arg := range call.args {
+		if literal, ok := arg.(*armString); ok && strings.Contains(literal.value, "/") {
+			return literal.value
+		}
+	}
+
+	return ""
+}
+
+func isARMExpression(value string) bool {
+	return strings.HasPrefix(value, "[") && !strings.HasPrefix(value, "[[") && strings.HasSuffix(value, "]")
+}
+
+func bicepString(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		`'`, `\'`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+		"${", `\${`,
+	)
+	return "'" + replacer.Replace(value) + "'"
+}
+
+func bicepKey(key string, b *bicepWriter) string {
+	if isARMExpression(key) {
+		if expression, err := b.expression(key[1 : len(key)-1]); err == nil {
+			return fmt.Sprintf("'${%s}'", expression)
+		}
+	}
+
+	if bicepIdentifier.MatchString(key) {
+		return key
+	}
+
+	return bicepString(key)
+}
+
+type orderedProperty struct {
+	key   string
+	value interface{}
+}
+
+type orderedObject []orderedProperty
+
+// toOrderedObject converts a value to an ordered representation of its JSON serialization so that properties are written in the same order as the JSON template
+func toOrderedObject(value interface{}) (orderedObject, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	result, err := decodeOrdered(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	object, ok := result.(orderedObject)
+	if !ok {
+		return nil, fmt.Errorf("Expected JSON object got %T", result)
+	}
+
+	return object, nil
+}
+
+func decodeOrdered(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, nil
+	}
+
+	switch delim {
+	case '{':
+		object := orderedObject{}
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := keyToken.(string)
+			if !ok {
+				return nil, fmt.Errorf("Expected JSON object key got %v", keyToken)
+			}
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, orderedProperty{key: key, value: value})
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		return object, nil
+	case '[':
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		return array, nil
+	}
+
+	return nil, fmt.Errorf("Unexpected JSON delimiter %v", delim)
+}
+
+// value converts a value from the template to a Bicep value
+func (b *bicepWriter) value(value interface{}, indent int) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "null", nil
+	case bool:
+		return fmt.Sprintf("%t", v), nil
+	case json.Number:
+		// Bicep only supports integer literals
+		if _, err := v.Int64(); err != nil {
+			return fmt.Sprintf("json('%s')", v.String()), nil
+		}
+		return v.String(), nil
+	case string:
+		if isARMExpression(v) {
+			return b.expression(v[1 : len(v)-1])
+		}
+		// A string starting with [[ is an escaped literal string starting with [
+		if strings.HasPrefix(v, "[[") {
+			v = v[1:]
+		}
+		return bicepString(v), nil
+	case []interface{}:
+		if len(v) == 0 {
+			return "[]", nil
+		}
+		builder := strings.Builder{}
+		builder.WriteString("[\n")
+		for _, item := range v {
+			converted, err := b.value(item, indent+1)
+			if err != nil {
+				return "", err
+			}
+			builder.WriteString(strings.Repeat(bicepIndent, indent+1))
+			builder.WriteString(converted)
+			builder.WriteString("\n")
+		}
+		builder.WriteString(strings.Repeat(bicepIndent, indent))
+		builder.WriteString("]")
+		return builder.String(), nil
+	case orderedObject:
+		if len(v) == 0 {
+			return "{}", nil
+		}
+		builder := strings.Builder{}
+		builder.WriteString("{\n")
+		for _, property := range v {
+			converted, err := b.value(property.value, indent+1)
+			if err != nil {
+				return "", err
+			}
+			builder.WriteString(strings.Repeat(bicepIndent, indent+1))
+			builder.WriteString(fmt.Sprintf("%s: %s\n", bicepKey(property.key, b), converted))
+		}
+		builder.WriteString(strings.Repeat(bicepIndent, indent))
+		builder.WriteString("}")
+		return builder.String(), nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		ordered, err := decodeOrdered(decoder)
+		if err != nil {
+			return "", err
+		}
+		return b.value(ordered, indent)
+	}
+}
+
+// expression converts an ARM template expression (without the enclosing square brackets) to a Bicep expression
+func (b *bicepWriter) expression(expression string) (string, error) {
+	parsed, err := parseARMExpression(expression)
+	if err != nil {
+		return "", err
+	}
+
+	return b.renderExpression(parsed)
+}
+
+func (b *bicepWriter) renderExpression(expression armExpression) (string, error) {
+	switch e := expression.(type) {
+	case *armString:
+		return bicepString(e.value), nil
+	case *armNumber:
+		return e.value, nil
+	case *armProperty:
+		target, err := b.renderExpression(e.target)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s.%s", target, e.name), nil
+	case *armIndex:
+		target, err := b.renderExpression(e.target)
+		if err != nil {
+			return "", err
+		}
+		index, err := b.renderExpression(e.index)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s[%s]", target, index), nil
+	case *armCall:
+		return b.renderCall(e)
+	}
+
+	return "", fmt.Errorf("Unsupported expression %v", expression)
+}
+
+func (b *bicepWriter) renderCall(call *armCall) (string, error) {
+	name := strings.ToLower(call.name)
+
+	args := make([]string, len(call.args))
+	for i := range call.args {
+		arg, err := b.renderExpression(call.args[i])
+		if err != nil {
+			return "", err
+		}
+		args[i] = arg
+	}
+
+	switch name {
+	case "parameters", "variables":
+		if len(call.args) != 1 {
+			return "", fmt.Errorf("%s expects a single argument", call.name)
+		}
+		literal, ok := call.args[0].(*armString)
+		if !ok {
+			return "", fmt.Errorf("%s requires a string literal argument to be converted to bicep", call.name)
+		}
+		symbols := b.parameters
+		if name == "variables" {
+			symbols = b.variables
+		}
+		symbol, ok := symbols[literal.value]
+		if !ok {
+			return "", fmt.Errorf("%s %s is not defined in the template", call.name, literal.value)
+		}
+		return symbol, nil
+	case "true", "false", "null":
+		return name, nil
+	case "not":
+		if len(args) != 1 {
+			return "", fmt.Errorf("not expects a single argument")
+		}
+		return fmt.Sprintf("!(%s)", args[0]), nil
+	case "if":
+		if len(args) != 3 {
+			return "", fmt.Errorf("if expects three arguments")
+		}
+		return fmt.Sprintf("(%s ? %s : %s)", args[0], args[1], args[2]), nil
+	case "createarray":
+		return fmt.Sprintf("[\n%s\n]", strings.Join(args, "\n")), nil
+	case "createobject":
+		if len(args)%2 != 0 {
+			return "", fmt.Errorf("createObject expects an even number of arguments")
+		}
+		builder := strings.Builder{}
+		builder.WriteString("{\n")
+		for i := 0; i < len(args); i += 2 {
+			key := fmt.Sprintf("'${%s}'", args[i])
+			if literal, ok := call.args[i].(*armString); ok {
+				key = bicepKey(literal.value, b)
+			}
+			builder.WriteString(fmt.Sprintf("%s: %s\n", key, args[i+1]))
+		}
+		builder.WriteString("}")
+		return builder.String(), nil
+	}
+
+	if operator, ok := bicepOperators[name]; ok {
+		if len(args) < 2 {
+			return "", fmt.Errorf("%s expects at least two arguments", call.name)
+		}
+		return fmt.Sprintf("(%s)", strings.Join(args, fmt.Sprintf(" %s ", operator))), nil
+	}
+
+	functionName := call.name
+	if bicepName, ok := bicepFunctionNames[name]; ok {
+		functionName = bicepName
+	}
+
+	return fmt.Sprintf("%s(%s)", functionName, strings.Join(args, ", ")), nil
+}
@@ -0,0 +1,132 @@
+package template
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestBicepExpressions(t *testing.T) {
+	b := bicepWriter{
+		parameters: map[string]string{"location": "location", "msi_name": "msi_name"},
+		variables:  map[string]string{"location": "locationVar", "port": "port"},
+		used:       map[string]bool{},
+	}
+
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"[parameters('location')]", "location"},
+		{"[variables('location')]", "locationVar"},
+		{"[resourceGroup().location]", "resourceGroup().location"},
+		{"[concat('cnabstate',uniqueString(resourceGroup().id))]", "concat('cnabstate', uniqueString(resourceGroup().id))"},
+		{"[if(equals(parameters('msi_name'),'it''s'),'a',variables('port'))]", "((msi_name == 'it\\'s') ? 'a' : port)"},
+		{"[reference(resourceId('Microsoft.ManagedIdentity/userAssignedIdentities',variables('port')), '2018-11-30').principalId]", "reference(resourceId('Microsoft.ManagedIdentity/userAssignedIdentities', port), '2018-11-30').principalId"},
+		{"[tolower(variables('port'))[0]]", "toLower(port)[0]"},
+		{"[[not an expression]", "'[not an expression]'"},
+		{"plain ${string}", "'plain \\${string}'"},
+	}
+
+	for _, test := range tests {
+		actual, err := b.value(test.value, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, test.expected, actual)
+	}
+}
+
+func TestToBicep(t *testing.T) {
+	minLength := 1
+	template := Template{
+		Metadata: map[string]interface{}{
+			BundleTagMetadataName: "example.azurecr.io/bundle:v1",
+		},
+		Parameters: map[string]Parameter{
+			"location": {
+				Type:          "string",
+				DefaultValue:  "[resourceGroup().location]",
+				AllowedValues: []string{"eastus", "westus"},
+				Metadata: &Metadata{
+					Description: "The location",
+				},
+			},
+			"password": {
+				Type:      "securestring",
+				MinLength: &minLength,
+			},
+		},
+		Variables: map[string]interface{}{
+			"location": "[parameters('location')]",
+		},
+		Resources: []Resource{
+			{
+				Type:       "Microsoft.ManagedIdentity/userAssignedIdentities",
+				Name:       "msi",
+				APIVersion: "2018-11-30",
+				Location:   "[variables('location')]",
+			},
+			{
+				Type:       "Microsoft.Storage/storageAccounts",
+				Name:       "[concat('storage', uniqueString(resourceGroup().id))]",
+				APIVersion: "2019-06-01",
+				Location:   "[variables('location')]",
+				DependsOn:  []string{"[resourceId('Microsoft.ManagedIdentity/userAssignedIdentities', 'msi')]"},
+				Sku: &Sku{
+					Name: "Standard_LRS",
+				},
+				Properties: Requests{
+					CPU:        1,
+					MemoryInGB: 1.5,
+				},
+			},
+		},
+		Outputs: map[string]Output{
+			"storage": {
+				Type:  "string",
+				Value: "[concat('storage', uniqueString(resourceGroup().id))]",
+			},
+		},
+	}
+
+	expected := `metadata bundleTag = 'example.azurecr.io/bundle:v1'
+
+@description('The location')
+@allowed([
+  'eastus'
+  'westus'
+])
+param location string = resourceGroup().location
+
+@minLength(1)
+@secure()
+param password string
+
+var locationVar = location
+
+resource userAssignedIdentities 'Microsoft.ManagedIdentity/userAssignedIdentities@2018-11-30' = {
+  name: 'msi'
+  location: locationVar
+}
+
+resource storageAccounts 'Microsoft.Storage/storageAccounts@2019-06-01' = {
+  name: concat('storage', uniqueString(resourceGroup().id))
+  location: locationVar
+  sku: {
+    name: 'Standard_LRS'
+  }
+  properties: {
+    cpu: 1
+    memoryInGB: json('1.5')
+  }
+  dependsOn: [
+    userAssignedIdentities
+  ]
+}
+
+output storage string = concat('storage', uniqueString(resourceGroup().id))
+`
+
+	actual, err := template.ToBicep()
+	assert.NilError(t, err)
+	assert.Equal(t, expected, actual)
+}
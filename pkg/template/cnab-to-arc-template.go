@@ -1,25 +1,25 @@
 package template
 
-// NewCnabarcTemplate creates a new instance of Template for running a CNAB bundle via the porter operator and arc
-func NewCnabArcTemplate(bundleName string, bundleTag string, isDogfood bool) (*Template, error) {
+import (
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/common"
+)
 
-	// TODO: does not handle credentials yet
+// NewCnabarcTemplate creates a new instance of Template for running a CNAB bundle via the porter operator and arc
+// actions is the list of actions that the bundle supports, the first action is used as the default
+// profile defines the resource provider that the installation resource is created in
+func NewCnabArcTemplate(bundleName string, bundleTag string, actions []string, profile *common.CloudProfile) (*Template, error) {
 
-	apiVersion := "2021-04-01-preview"
-	resourceType := "Microsoft.Contoso/installations"
-	location := "eastus2euap"
-	if isDogfood {
-		resourceType = "Microsoft.CNAB/installations"
-		apiVersion = "2021-02-12-preview"
-		location = "westUS"
+	// The installation resource is created in the first location of the profile
+	if err := profile.Validate(); err != nil {
+		return nil, err
 	}
 
 	resources := []Resource{
 		{
-			Type:       resourceType,
+			Type:       profile.FullResourceType(),
 			Name:       "[parameters('installation_name')]",
-			APIVersion: apiVersion,
-			Location:   location,
+			APIVersion: profile.APIVersion,
+			Location:   profile.Locations[0],
 			ExtendedLocation: &ExtendedLocationProperties{
 				Type: "customLocation",
 				Name: "[concat('/subscriptions/', subscription().subscriptionId, '/resourceGroups/',parameters('customLocationRG'),'/providers/Microsoft.ExtendedLocation/customLocations/',parameters('customLocationResource'))]",
@@ -50,8 +50,9 @@ func NewCnabArcTemplate(bundleName string, bundleTag string, isDogfood bool) (*T
 	}
 
 	parameters["action"] = Parameter{
-		Type:         "string",
-		DefaultValue: "install",
+		Type:          "string",
+		DefaultValue:  actions[0],
+		AllowedValues: actions,
 		Metadata: &Metadata{
 			Description: "The CNAB Action to perform.",
 		},
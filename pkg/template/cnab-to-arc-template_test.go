@@ -0,0 +1,27 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/common"
+	"gotest.tools/assert"
+)
+
+func TestArcTemplateUsesProfileLocation(t *testing.T) {
+	profile := common.CloudProfile{
+		Name:              "test",
+		ProviderNamespace: "Microsoft.Test",
+		ResourceType:      "installations",
+		APIVersion:        "2021-01-01",
+		Locations:         []string{"westus", "eastus"},
+	}
+
+	arcTemplate, err := NewCnabArcTemplate("test", "example.com/test:v1", []string{"install"}, &profile)
+	assert.NilError(t, err)
+	assert.Equal(t, "Microsoft.Test/installations", arcTemplate.Resources[0].Type)
+	assert.Equal(t, "westus", arcTemplate.Resources[0].Location)
+
+	profile.Locations = nil
+	_, err = NewCnabArcTemplate("test", "example.com/test:v1", []string{"install"}, &profile)
+	assert.ErrorContains(t, err, "Cloud profile test is missing required properties: locations")
+}
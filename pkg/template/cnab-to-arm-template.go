@@ -1,17 +1,34 @@
 package template
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/cnabio/cnab-go/bundle"
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/common"
 )
 
+// credentialFilesDirectory is the directory that the deployment script decodes file credentials to
+const credentialFilesDirectory = "/tmp/cnab-credentials"
+
 // NewCnabArmDriverTemplate creates a new instance of Template for running a CNAB bundle using cnab-azure-driver
-func NewCnabArmDriverTemplate(bundleName string, bundleTag string, outputs map[string]bundle.Output, simplify bool, timeout int, debug bool) (*Template, error) {
+// actions is the list of bundle actions that the template can run, the first action is the default
+// outputs is a map of bundle output name to ARM type, each output is exposed as an output of the template
+// keyVaultOutputs is a list of sensitive bundle outputs that are stored in a key vault created by the template, the secret URI is exposed as the output
+// cloud is the Azure cloud environment that the template will be deployed to, locations restricts the values of the location parameter unless it is empty
+// versions defines the default versions and checksums of the tools that the deployment script downloads
+// mirrorURL is the default base URL that the tools are downloaded from, if it is empty the tools are downloaded from the internet
+func NewCnabArmDriverTemplate(bundleName string, bundleTag string, actions []string, outputs map[string]string, keyVaultOutputs []string, cloud *common.CloudEnvironment, locations []string, versions common.ToolVersions, mirrorURL string, simplify bool, timeout int, debug bool) (*Template, error) {
+
+	versions = versions.WithDefaults()
+
+	script, err := createScript(bundleName, bundleTag, outputs, keyVaultOutputs, len(mirrorURL) > 0, debug)
+	if err != nil {
+		return nil, err
+	}
 
 	duration, _ := time.ParseDuration(fmt.Sprintf("%dm", timeout))
 	executionTimeout := fmt.Sprintf("PT%s", strings.ToUpper(duration.String()))
@@ -139,13 +156,37 @@ func NewCnabArmDriverTemplate(bundleName string, bundleTag string, outputs map[s
 						Name:  "CNAB_AZURE_DELETE_RESOURCES",
 						Value: "[variables('cnab_azure_delete_resources')]",
 					},
+					{
+						Name:  "CNAB_ALLOW_BUNDLE_CHANGE",
+						Value: "[string(parameters('allow_bundle_change'))]",
+					},
+					{
+						Name:  "PORTER_CHECKSUM",
+						Value: "[variables('porter_checksum')]",
+					},
+					{
+						Name:  "AZURE_PLUGIN_VERSION",
+						Value: "[variables('azure_plugin_version')]",
+					},
+					{
+						Name:  "AZURE_PLUGIN_CHECKSUM",
+						Value: "[variables('azure_plugin_checksum')]",
+					},
+					{
+						Name:  "CNAB_AZURE_DRIVER_VERSION",
+						Value: "[variables('cnab_azure_driver_version')]",
+					},
+					{
+						Name:  "CNAB_AZURE_DRIVER_CHECKSUM",
+						Value: "[variables('cnab_azure_driver_checksum')]",
+					},
 					{
 						Name:        "AZURE_STORAGE_CONNECTION_STRING",
-						SecureValue: "[format('AccountName={0};AccountKey={1}', variables('cnab_azure_state_storage_account_name'), listKeys(resourceId('Microsoft.Storage/storageAccounts', variables('cnab_azure_state_storage_account_name')), '2019-06-01').keys[0].value)]",
+						SecureValue: fmt.Sprintf("[format('AccountName={0};AccountKey={1};EndpointSuffix=%s', variables('cnab_azure_state_storage_account_name'), listKeys(resourceId('Microsoft.Storage/storageAccounts', variables('cnab_azure_state_storage_account_name')), '2019-06-01').keys[0].value)]", cloud.StorageEndpointSuffix),
 					},
 				},
-				Arguments:     "[format('{0} {1}',variables('porter_version'),parameters('cnab_installation_name'))]",
-				ScriptContent: createScript(bundleTag, debug),
+				Arguments:     "[format('{0} {1} {2}',variables('porter_version'),parameters('cnab_installation_name'),parameters('action'))]",
+				ScriptContent: script,
 			},
 		},
 	}
@@ -168,39 +209,25 @@ func NewCnabArmDriverTemplate(bundleName string, bundleTag string, outputs map[s
 		},
 	}
 
+	parameters["action"] = Parameter{
+		Type:          "string",
+		DefaultValue:  actions[0],
+		AllowedValues: actions,
+		Metadata: &Metadata{
+			Description: "The CNAB Action to perform, install upgrades the installation if it already exists.",
+		},
+	}
+
+	parameters["allow_bundle_change"] = Parameter{
+		Type:         "bool",
+		DefaultValue: common.ParameterDefaults["allow_bundle_change"],
+		Metadata: &Metadata{
+			Description: "Allow an existing installation of a different bundle with the same installation name to be upgraded to this bundle.",
+		},
+	}
+
 	if !simplify {
-		// TODO:The allowed values should be generated automatically based on ACI availability
-		parameters["location"] = Parameter{
-			Type: "string",
-			AllowedValues: []string{
-				"australiaeast",
-				"brazilsouth",
-				"canadacentral",
-				"centralindia",
-				"centralus",
-				"chinaeast2",
-				"eastasia",
-				"eastus",
-				"eastus2",
-				"francecentral",
-				"japaneast",
-				"koreacentral",
-				"northcentralus",
-				"northeurope",
-				"southcentralus",
-				"southeastasia",
-				"southindia",
-				"uksouth",
-				"westeurope",
-				"westcentralus",
-				"westus",
-				"westus2",
-			},
-			Metadata: &Metadata{
-				Description: "The location in which the resources will be created.",
-			},
-			DefaultValue: common.ParameterDefaults["location"],
-		}
+		parameters["location"] = newLocationParameter(locations)
 
 		parameters["deployment_script_cleanup"] = Parameter{
 			Type: "string",
@@ -300,15 +327,63 @@ func NewCnabArmDriverTemplate(bundleName string, bundleTag string, outputs map[s
 			Metadata: &Metadata{
 				Description: "The version of porter to use",
 			},
-			DefaultValue: common.ParameterDefaults["porter_version"],
+			DefaultValue: versions.PorterVersion,
+		}
+
+		parameters["porter_checksum"] = Parameter{
+			Type: "string",
+			Metadata: &Metadata{
+				Description: "The SHA-256 checksum of the porter binary, if this is empty the checksum is not verified",
+			},
+			DefaultValue: versions.PorterChecksum,
+		}
+
+		parameters["azure_plugin_version"] = Parameter{
+			Type: "string",
+			Metadata: &Metadata{
+				Description: "The version of the porter azure plugin to use",
+			},
+			DefaultValue: versions.AzurePluginVersion,
+		}
+
+		parameters["azure_plugin_checksum"] = Parameter{
+			Type: "string",
+			Metadata: &Metadata{
+				Description: "The SHA-256 checksum of the porter azure plugin binary, if this is empty the checksum is not verified",
+			},
+			DefaultValue: versions.AzurePluginChecksum,
+		}
+
+		parameters["cnab_azure_driver_version"] = Parameter{
+			Type: "string",
+			Metadata: &Metadata{
+				Description: "The version of the cnab azure driver to use",
+			},
+			DefaultValue: versions.AzureDriverVersion,
+		}
+
+		parameters["cnab_azure_driver_checksum"] = Parameter{
+			Type: "string",
+			Metadata: &Metadata{
+				Description: "The SHA-256 checksum of the cnab azure driver binary, if this is empty the checksum is not verified",
+			},
+			DefaultValue: versions.AzureDriverChecksum,
 		}
 	}
 
-	output := map[string]Output{
-		"BundleOutput": {
-			Type:  "array",
-			Value: "[reference(resourceId('Microsoft.Resources/deploymentScripts',variables('deploymentScriptResourceName')), '2019-10-01-preview').Outputs.BundleOutputs]",
-		},
+	output := make(map[string]Output, len(outputs))
+	for name, armType := range outputs {
+		output[name] = Output{
+			Type:  armType,
+			Value: fmt.Sprintf("[reference(resourceId('Microsoft.Resources/deploymentScripts',variables('deploymentScriptResourceName')), '2019-10-01-preview').outputs['%s']]", name),
+		}
+	}
+
+	for _, name := range keyVaultOutputs {
+		output[name] = Output{
+			Type:  "string",
+			Value: fmt.Sprintf("[reference(resourceId('Microsoft.Resources/deploymentScripts',variables('deploymentScriptResourceName')), '2019-10-01-preview').outputs['%s']]", name),
+		}
 	}
 
 	template := Template{
@@ -329,14 +404,225 @@ func NewCnabArmDriverTemplate(bundleName string, bundleTag string, outputs map[s
 	resource.Identity.UserAssignedIdentities = userIdentity
 
 	if simplify {
-		template.addSimpleVariables(bundleName, executionTimeout, debug)
+		template.addSimpleVariables(bundleName, executionTimeout, versions, debug)
 	} else {
 		template.addAdvancedVariables(debug)
 	}
 
+	if len(keyVaultOutputs) > 0 {
+		if err := template.addKeyVault(simplify); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(mirrorURL) > 0 {
+		if err := template.addToolsMirror(mirrorURL); err != nil {
+			return nil, err
+		}
+	}
+
 	return &template, nil
 }
 
+// addToolsMirror adds the parameters used by the deployment script to download the tools from a mirror rather than the internet
+func (template *Template) addToolsMirror(mirrorURL string) error {
+	template.Parameters["tools_mirror_url"] = Parameter{
+		Type: "string",
+		Metadata: &Metadata{
+			Description: "The base URL of the mirror that porter, the porter azure plugin and the cnab azure driver are downloaded from",
+		},
+		DefaultValue: mirrorURL,
+	}
+
+	template.Parameters["tools_mirror_sas_token"] = Parameter{
+		Type: "securestring",
+		Metadata: &Metadata{
+			Description: "The SAS token used to access the mirror if it is a blob container, leave empty if the mirror does not require authentication",
+		},
+		DefaultValue: "",
+	}
+
+	if err := template.SetDeploymentScriptEnvironmentVariable(EnvironmentVariable{
+		Name:  "TOOLS_MIRROR_URL",
+		Value: "[parameters('tools_mirror_url')]",
+	}); err != nil {
+		return err
+	}
+
+	return template.SetDeploymentScriptEnvironmentVariable(EnvironmentVariable{
+		Name:        "TOOLS_MIRROR_SAS_TOKEN",
+		SecureValue: "[parameters('tools_mirror_sas_token')]",
+	})
+}
+
+// addKeyVault adds a key vault to the template that the deployment script uses to store sensitive bundle outputs
+func (template *Template) addKeyVault(simplify bool) error {
+	template.Resources = append(template.Resources, Resource{
+		Type:       "Microsoft.KeyVault/vaults",
+		Name:       "[variables('keyvault_name')]",
+		APIVersion: "2019-09-01",
+		Location:   "[variables('location')]",
+		DependsOn: []string{
+			"[resourceId('Microsoft.ManagedIdentity/userAssignedIdentities', variables('msi_name'))]",
+		},
+		Properties: KeyVaultProperties{
+			TenantId: "[subscription().tenantId]",
+			Sku: KeyVaultSku{
+				Family: "A",
+				Name:   "standard",
+			},
+			AccessPolicies: []KeyVaultAccessPolicy{
+				{
+					TenantId: "[subscription().tenantId]",
+					ObjectId: "[reference(resourceId('Microsoft.ManagedIdentity/userAssignedIdentities',variables('msi_name')), '2018-11-30').principalId]",
+					Permissions: KeyVaultPermissions{
+						Secrets: []string{
+							"get",
+							"list",
+							"set",
+						},
+					},
+				},
+			},
+		},
+	})
+
+	resource, err := template.FindResource(DeploymentScriptName)
+	if err != nil {
+		return fmt.Errorf("Failed to find deployment script resource: %w", err)
+	}
+	resource.DependsOn = append(resource.DependsOn, "[resourceId('Microsoft.KeyVault/vaults', variables('keyvault_name'))]")
+
+	if err := template.SetDeploymentScriptEnvironmentVariable(EnvironmentVariable{
+		Name:  "CNAB_KEYVAULT_NAME",
+		Value: "[variables('keyvault_name')]",
+	}); err != nil {
+		return err
+	}
+
+	if simplify {
+		template.Variables["keyvault_name"] = common.ParameterDefaults["keyvault_name"]
+	} else {
+		template.Parameters["keyvault_name"] = Parameter{
+			Type: "string",
+			Metadata: &Metadata{
+				Description: "The name of the key vault used to store sensitive bundle outputs, the key vault will be created if it does not exist",
+			},
+			DefaultValue: common.ParameterDefaults["keyvault_name"],
+		}
+		template.Variables["keyvault_name"] = "[parameters('keyvault_name')]"
+	}
+
+	return nil
+}
+
+// SetDeploymentScriptParameterSets sets the deployment script environment variable that contains the porter parameter set for the installation,
+// actionValues is a map of bundle action to a map of bundle parameter name to the ARM expression for the value of the parameter without the enclosing brackets.
+// The parameter set for each action is selected inline by the action parameter at deployment time, the values may use list functions and secure parameters which cannot be used in template variables
+func (template *Template) SetDeploymentScriptParameterSets(actionValues map[string]map[string]string) error {
+	actions := make([]string, 0, len(actionValues))
+	for action := range actionValues {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	parameters := "json('[]')"
+	for i := len(actions) - 1; i >= 0; i-- {
+		parameters = fmt.Sprintf("if(equals(parameters('action'),'%s'),%s,%s)", strings.ReplaceAll(actions[i], "'", "''"), valueSetArray(actionValues[actions[i]], nil), parameters)
+	}
+
+	return template.SetDeploymentScriptEnvironmentVariable(EnvironmentVariable{
+		Name:        common.GetEnvironmentVariableNames().CnabParameterSet,
+		SecureValue: fmt.Sprintf("[string(createObject('Name',parameters('cnab_installation_name'),'Parameters',%s))]", parameters),
+	})
+}
+
+// SetDeploymentScriptCredentialSet sets the deployment script environment variable that contains the porter credential set for the installation,
+// values is a map of bundle credential name to the ARM expression for the value of the credential without the enclosing brackets.
+// fileValues is a map of bundle file credential name to the ARM expression for the base64 encoded content of the file, the content is passed to the script in a separate environment variable and decoded to a file so that binary files are not corrupted
+func (template *Template) SetDeploymentScriptCredentialSet(values map[string]string, fileValues map[string]string) error {
+	if len(values) == 0 && len(fileValues) == 0 {
+		return nil
+	}
+
+	paths := make(map[string]string, len(fileValues))
+	entries := make([]string, 0, len(fileValues))
+	for _, name := range sortedNames(fileValues) {
+		paths[name] = credentialFilePath(name)
+		entries = append(entries, fmt.Sprintf("'%s',%s", strings.ReplaceAll(name, "'", "''"), fileValues[name]))
+	}
+
+	if len(entries) > 0 {
+		if err := template.SetDeploymentScriptEnvironmentVariable(EnvironmentVariable{
+			Name:        common.GetEnvironmentVariableNames().CnabCredentialFiles,
+			SecureValue: fmt.Sprintf("[string(createObject(%s))]", strings.Join(entries, ",")),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return template.SetDeploymentScriptEnvironmentVariable(EnvironmentVariable{
+		Name:        common.GetEnvironmentVariableNames().CnabCredentialSet,
+		SecureValue: fmt.Sprintf("[string(createObject('Name',parameters('cnab_installation_name'),'Credentials',%s))]", valueSetArray(values, paths)),
+	})
+}
+
+// credentialFilePath returns the path of the file that the deployment script decodes a file credential to
+func credentialFilePath(name string) string {
+	return fmt.Sprintf("%s/%s", credentialFilesDirectory, name)
+}
+
+// valueSetArray returns the ARM expression for the array of values in a porter parameter or credential set,
+// values is a map of name to the ARM expression for the value and paths is a map of name to the path of a file containing the value
+func valueSetArray(values map[string]string, paths map[string]string) string {
+	if len(values) == 0 && len(paths) == 0 {
+		return "json('[]')"
+	}
+
+	sources := make(map[string]string, len(values)+len(paths))
+	for name, value := range values {
+		sources[name] = fmt.Sprintf("createObject('Value',%s)", value)
+	}
+	for name, path := range paths {
+		sources[name] = fmt.Sprintf("createObject('Path','%s')", strings.ReplaceAll(path, "'", "''"))
+	}
+
+	names := sortedNames(sources)
+	entries := make([]string, len(names))
+	for i, name := range names {
+		entries[i] = fmt.Sprintf("createObject('Name','%s','Source',%s)", strings.ReplaceAll(name, "'", "''"), sources[name])
+	}
+
+	return fmt.Sprintf("createArray(%s)", strings.Join(entries, ","))
+}
+
+// sortedNames returns the keys of values in order so that the generated expressions are stable
+func sortedNames(values map[string]string) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newLocationParameter creates the location parameter, the allowed values are only set if locations is not empty
+func newLocationParameter(locations []string) Parameter {
+	parameter := Parameter{
+		Type: "string",
+		Metadata: &Metadata{
+			Description: "The location in which the resources will be created.",
+		},
+		DefaultValue: common.ParameterDefaults["location"],
+	}
+
+	if len(locations) > 0 {
+		parameter.AllowedValues = locations
+	}
+
+	return parameter
+}
+
 func (template *Template) addAdvancedVariables(debug bool) {
 	variables := map[string]interface{}{
 		"cnab_resource_group":                   "[parameters('cnab_resource_group')]",
@@ -353,13 +639,18 @@ func (template *Template) addAdvancedVariables(debug bool) {
 		"deploymentScriptResourceName":          "[parameters('deploymentScriptResourceName')]",
 		"contributorRoleDefinitionId":           "[concat('/subscriptions/', subscription().subscriptionId, '/providers/Microsoft.Authorization/roleDefinitions/', 'b24988ac-6180-42a0-ab88-20f7382dd24c')]",
 		"porter_version":                        "[parameters('porter_version')]",
+		"porter_checksum":                       "[parameters('porter_checksum')]",
+		"azure_plugin_version":                  "[parameters('azure_plugin_version')]",
+		"azure_plugin_checksum":                 "[parameters('azure_plugin_checksum')]",
+		"cnab_azure_driver_version":             "[parameters('cnab_azure_driver_version')]",
+		"cnab_azure_driver_checksum":            "[parameters('cnab_azure_driver_checksum')]",
 		"timeout":                               "[parameters('timeout')]",
 	}
 
 	template.Variables = variables
 }
 
-func (template *Template) addSimpleVariables(bundleName string, executionTimeout string, debug bool) {
+func (template *Template) addSimpleVariables(bundleName string, executionTimeout string, versions common.ToolVersions, debug bool) {
 	cleanup := "Always"
 	if debug {
 		cleanup = "OnExpiration"
@@ -378,68 +669,152 @@ func (template *Template) addSimpleVariables(bundleName string, executionTimeout
 		"roleAssignmentId":                      "[guid(concat(resourceGroup().id,variables('msi_name'), 'contributor'))]",
 		"deploymentScriptResourceName":          fmt.Sprintf("[concat('cnab-',uniqueString(resourceGroup().id, '%s'))]", bundleName),
 		"contributorRoleDefinitionId":           "[concat('/subscriptions/', subscription().subscriptionId, '/providers/Microsoft.Authorization/roleDefinitions/', 'b24988ac-6180-42a0-ab88-20f7382dd24c')]",
-		"porter_version":                        "latest",
+		"porter_version":                        versions.PorterVersion,
+		"porter_checksum":                       versions.PorterChecksum,
+		"azure_plugin_version":                  versions.AzurePluginVersion,
+		"azure_plugin_checksum":                 versions.AzurePluginChecksum,
+		"cnab_azure_driver_version":             versions.AzureDriverVersion,
+		"cnab_azure_driver_checksum":            versions.AzureDriverChecksum,
 		"timeout":                               executionTimeout,
 	}
 
 	template.Variables = variables
 }
 
-func createScript(tag string, debug bool) string {
+// addInstallationChecks adds steps that select the action to perform based on whether the installation in the second argument exists and that check that an existing installation is an installation of bundleName.
+// The bundle of an installation is read from the bundle in the installation claim, if the bundle cannot be determined the action is only performed if allow_bundle_change is set
+func addInstallationChecks(script *Script, bundleName string) {
+	script.SetLiteralVariable("BUNDLE_NAME", bundleName)
+	script.SetVariable("ACTION", "${3}")
+	script.SetLiteralVariable("INSTALLED", "false")
+	script.SetVariable("INSTALLED_BUNDLE", "")
+	script.AddIf(`INSTALLATION="$(porter show "${2}" -o json 2>/dev/null)" && [[ -n "${INSTALLATION}" ]]`,
+		"INSTALLED='true'",
+		`INSTALLED_BUNDLE="$(echo "${INSTALLATION}" | jq -r '.bundle | objects | .name // empty')"`)
+
+	// install and upgrade install the bundle if the installation does not exist, otherwise the installation is upgraded
+	script.AddIf(`[[ "${ACTION}" == 'install' && "${INSTALLED}" == 'true' ]]`, "ACTION='upgrade'")
+	script.AddIf(`[[ "${ACTION}" == 'upgrade' && "${INSTALLED}" == 'false' ]]`, "ACTION='install'")
+	script.AddIf(`[[ "${ACTION}" != 'install' && "${INSTALLED}" == 'false' ]]`,
+		`echo "Installation ${2} does not exist, the ${ACTION} action can only be performed on an existing installation" >&2`,
+		"exit 1")
+
+	// An action is only performed on an existing installation if it is an installation of the same bundle, unless allow_bundle_change is set
+	script.AddIf(`[[ "${INSTALLED}" == 'true' && "${INSTALLED_BUNDLE}" != "${BUNDLE_NAME}" && "${CNAB_ALLOW_BUNDLE_CHANGE,,}" != 'true' ]]`,
+		`if [[ -z "${INSTALLED_BUNDLE}" ]]; then echo "Unable to determine the bundle of installation ${2}, set the allow_bundle_change parameter to true to perform the ${ACTION} action using bundle ${BUNDLE_NAME}" >&2; exit 1; fi`,
+		`echo "Installation ${2} is an installation of bundle ${INSTALLED_BUNDLE} not ${BUNDLE_NAME}, set the allow_bundle_change parameter to true to perform the ${ACTION} action using bundle ${BUNDLE_NAME}" >&2`,
+		"exit 1")
+}
+
+func createScript(bundleName string, bundleReference string, outputs map[string]string, keyVaultOutputs []string, mirror bool, debug bool) (string, error) {
 	porterDebug := ""
 	if debug {
 		porterDebug = "--debug"
 	}
-	//TODO allow version specification for azure driver and plugin
-	installsteps := []string{
-		"set -euxo pipefail",
-		"PORTER_HOME=${HOME}/.porter",
-		"PORTER_URL=https://cdn.porter.sh",
-		"PORTER_VERSION=${1}",
-		"mkdir -p ${PORTER_HOME}",
-		"curl -fsSLo ${PORTER_HOME}/porter ${PORTER_URL}/${PORTER_VERSION}/porter-linux-amd64",
-		"chmod +x ${PORTER_HOME}/porter",
-		"export PATH=\"${PORTER_HOME}:${PATH}\"",
-		"${PORTER_HOME}/porter plugin install azure --version $PORTER_VERSION",
-		"echo 'default-storage-plugin = \"azure.table\"' > ${PORTER_HOME}/config.toml",
-		"cat ${PORTER_HOME}/config.toml",
-		"DOWNLOAD_LOCATION=$( curl -sL https://api.github.com/repos/deislabs/cnab-azure-driver/releases/latest | jq '.assets[]|select(.name==\"cnab-azure-linux-amd64\").browser_download_url' -r)",
-		"mkdir -p ${HOME}/.cnab-azure-driver",
-		"curl -sSLo ${HOME}/.cnab-azure-driver/cnab-azure ${DOWNLOAD_LOCATION}",
-		"chmod +x ${HOME}/.cnab-azure-driver/cnab-azure",
-		"export PATH=${HOME}/.cnab-azure-driver:${PATH}",
-		"set +e",
-		"INSTANCE=$(${HOME}/.porter/porter show \"${2}\" -o json)",
-		"set -e",
-		"ACTION='upgrade'",
-		//TODO if the bundle exists then check that the installation is for the same bundle type
-		"if [[ -z ${INSTANCE} ]]; then ACTION='install'; fi",
-		"export CNAB_ACTION=${ACTION}",
-		"SUFFIX=",
-		"PARAMSFILE=$(mktemp)",
-		"PARAMS=\" -p ${PARAMSFILE}\"",
-		"echo {\\\"Name\\\": \\\"${2}\\\" , > ${PARAMSFILE}",
-		"echo \\\"Parameters\\\":[ >> ${PARAMSFILE}",
-		"for env_var in ${!CNAB_PARAM_@};do NAME=${env_var#CNAB_PARAM_};echo ${SUFFIX}  >> ${PARAMSFILE};echo {\\\"Name\\\":\\\"$NAME\\\" , >> ${PARAMSFILE};echo \\\"Source\\\": { >> ${PARAMSFILE};echo \\\"Env\\\": \\\"${env_var}\\\" >> ${PARAMSFILE};echo }} >> ${PARAMSFILE}; if [[ -z ${SUFFIX} ]];then SUFFIX=','; fi;  done",
-		"echo ]} >> ${PARAMSFILE}",
-		"cat ${PARAMSFILE}",
-		"CREDS=",
-		"SUFFIX=",
-		"for env_var in ${!CNAB_CRED_FILE@};do NAME=${env_var#CNAB_CRED_FILE_};echo ${!env_var}|base64 -d > /tmp/${NAME}; done",
-		"if [[  ! -z  ${!CNAB_CRED_@} ]];then CREDSFILE=$(mktemp);CREDS=\" --cred ${CREDSFILE}\";echo {\\\"Name\\\": \\\"${2}\\\" , > ${CREDSFILE};echo \\\"Credentials\\\":[ >> ${CREDSFILE}; for env_var in ${!CNAB_CRED_@};do NAME=${env_var#CNAB_CRED_};echo ${SUFFIX}>> ${CREDSFILE};if [[ ${NAME} = FILE_* ]];then NAME=${NAME#FILE_};fi;echo {\\\"Name\\\":\\\"$NAME\\\" , >> ${CREDSFILE};echo \\\"Source\\\": { >> ${CREDSFILE};if [[ ${env_var} = CNAB_CRED_FILE_* ]];then echo \\\"Path\\\": \\\"/tmp/${NAME}\\\" >> ${CREDSFILE};else echo \\\"Env\\\": \\\"${env_var}\\\" >> ${CREDSFILE};fi; echo }} >> ${CREDSFILE}; if [[ -z ${SUFFIX} ]];then SUFFIX=','; fi;  done;echo ]} >> ${CREDSFILE};fi",
-		//TODO update tags to reference
-		fmt.Sprintf("TAG=%s", tag),
-		fmt.Sprintf("PORTER_DEBUG=%s", porterDebug),
-		"porter bundle ${ACTION} \"${2}\" ${PARAMS} ${CREDS} --reference ${TAG} -d azure ${PORTER_DEBUG}",
-		//TODO deal with sensitve outputs
-		"OUTPUTS=$(porter inst outputs list -i \"${2}\" -o json)",
-		"echo OUTPUTS: ${OUTPUTS}",
-		"if [[ -z ${OUTPUTS} ]]; then echo []|jq '{BundleOutputs: .}';  else echo $OUTPUTS|jq '{BundleOutputs: .}' > ${AZ_SCRIPTS_OUTPUT_PATH}; fi"}
-
-	builder := strings.Builder{}
-	for _, cmd := range installsteps {
-		builder.WriteString(fmt.Sprintf("%s;", cmd))
-	}
-
-	return builder.String()
+
+	// The script uses the output types to select the outputs to return and to convert non string values from JSON
+	outputTypes, err := json.Marshal(outputs)
+	if err != nil {
+		return "", fmt.Errorf("Failed to serialise output types: %w", err)
+	}
+
+	// The secret id of each key vault output is empty until the output is stored in the key vault
+	emptySecretIds := make(map[string]string, len(keyVaultOutputs))
+	for _, name := range keyVaultOutputs {
+		emptySecretIds[name] = ""
+	}
+	secretIds, err := json.Marshal(emptySecretIds)
+	if err != nil {
+		return "", fmt.Errorf("Failed to serialise secret ids: %w", err)
+	}
+
+	script := NewScript()
+	script.AddComment("verify_checksum verifies the SHA-256 checksum of the file in the first argument if a checksum is passed in the second argument")
+	script.AddFunction("verify_checksum",
+		`if [[ -n "${2:-}" ]]; then echo "${2}  ${1}" | sha256sum -c -; fi`)
+	script.SetVariable("PORTER_HOME", "${HOME}/.porter")
+	script.SetVariable("PORTER_VERSION", "${1}")
+	script.AddSteps(`mkdir -p "${PORTER_HOME}/plugins/azure" "${HOME}/.cnab-azure-driver"`)
+
+	if mirror {
+		// The mirror contains a directory for each tool, the porter directory holds the files that cdn.porter.sh serves at its root and the
+		// plugins directory matches cdn.porter.sh. The SAS token is not traced so that it is not written to the deployment script log
+		script.AddFunction("mirror_download",
+			"{ set +x; } 2>/dev/null",
+			`curl -fsSLo "${1}" "${TOOLS_MIRROR_URL%/}/${2}${TOOLS_MIRROR_SAS_TOKEN:+?${TOOLS_MIRROR_SAS_TOKEN#\?}}"`,
+			"set -x")
+		script.AddSteps(
+			`mirror_download "${PORTER_HOME}/porter" "porter/${PORTER_VERSION}/porter-linux-amd64"`,
+			`verify_checksum "${PORTER_HOME}/porter" "${PORTER_CHECKSUM:-}"`,
+			`mirror_download "${PORTER_HOME}/plugins/azure/azure" "plugins/azure/${AZURE_PLUGIN_VERSION}/azure-linux-amd64"`,
+			`verify_checksum "${PORTER_HOME}/plugins/azure/azure" "${AZURE_PLUGIN_CHECKSUM:-}"`,
+			`chmod +x "${PORTER_HOME}/porter" "${PORTER_HOME}/plugins/azure/azure"`,
+			`mirror_download "${HOME}/.cnab-azure-driver/cnab-azure" "cnab-azure-driver/${CNAB_AZURE_DRIVER_VERSION}/cnab-azure-linux-amd64"`)
+	} else {
+		// The plugin is downloaded from the location that porter plugin install uses rather than installed by porter so that it is verified before it is run
+		script.SetLiteralVariable("PORTER_URL", "https://cdn.porter.sh")
+		script.AddSteps(
+			`curl -fsSLo "${PORTER_HOME}/porter" "${PORTER_URL}/${PORTER_VERSION}/porter-linux-amd64"`,
+			`verify_checksum "${PORTER_HOME}/porter" "${PORTER_CHECKSUM:-}"`,
+			`curl -fsSLo "${PORTER_HOME}/plugins/azure/azure" "${PORTER_URL}/plugins/azure/${AZURE_PLUGIN_VERSION}/azure-linux-amd64"`,
+			`verify_checksum "${PORTER_HOME}/plugins/azure/azure" "${AZURE_PLUGIN_CHECKSUM:-}"`,
+			`chmod +x "${PORTER_HOME}/porter" "${PORTER_HOME}/plugins/azure/azure"`)
+		script.AddIfElse(`[[ "${CNAB_AZURE_DRIVER_VERSION}" == 'latest' ]]`,
+			[]string{`DOWNLOAD_LOCATION=$(curl -fsSL https://api.github.com/repos/deislabs/cnab-azure-driver/releases/latest | jq -r '.assets[] | select(.name == "cnab-azure-linux-amd64").browser_download_url')`},
+			[]string{`DOWNLOAD_LOCATION="https://github.com/deislabs/cnab-azure-driver/releases/download/${CNAB_AZURE_DRIVER_VERSION}/cnab-azure-linux-amd64"`})
+		script.AddSteps(`curl -fsSLo "${HOME}/.cnab-azure-driver/cnab-azure" "${DOWNLOAD_LOCATION}"`)
+	}
+
+	script.AddSteps(
+		`verify_checksum "${HOME}/.cnab-azure-driver/cnab-azure" "${CNAB_AZURE_DRIVER_CHECKSUM:-}"`,
+		`chmod +x "${HOME}/.cnab-azure-driver/cnab-azure"`)
+	script.ExportVariable("PATH", "${PORTER_HOME}:${HOME}/.cnab-azure-driver:${PATH}")
+	script.AddSteps(
+		`echo 'default-storage-plugin = "azure.table"' > "${PORTER_HOME}/config.toml"`,
+		`cat "${PORTER_HOME}/config.toml"`)
+
+	addInstallationChecks(script, bundleName)
+	script.ExportVariable("CNAB_ACTION", "${ACTION}")
+
+	// Custom actions are run using porter bundle invoke
+	script.SetVariable("PORTER_COMMAND", "${ACTION}")
+	script.AddIf(fmt.Sprintf(`[[ ' %s ' != *" ${ACTION} "* ]]`, strings.Join(common.BuiltInActions, " ")),
+		`PORTER_COMMAND="invoke --action ${ACTION}"`)
+
+	// The parameter and credential sets are generated by the template and contain the values for the installation
+	envVarNames := common.GetEnvironmentVariableNames()
+	script.AddEnvironmentVariableFile("PARAMS_FILE", envVarNames.CnabParameterSet)
+	script.AddEnvironmentVariableFile("CREDS_FILE", envVarNames.CnabCredentialSet)
+	// File credentials are passed base64 encoded and decoded to the files that the credential set refers to
+	script.AddIf(fmt.Sprintf(`[[ -n "${%s:-}" ]]`, envVarNames.CnabCredentialFiles),
+		fmt.Sprintf(`mkdir -p %s`, shellQuote(credentialFilesDirectory)),
+		fmt.Sprintf(`printenv %s | jq -r 'keys[]' | while IFS= read -r NAME; do printenv %s | jq -r --arg name "${NAME}" '.[$name]' | base64 -d > "%s/${NAME}"; done`, envVarNames.CnabCredentialFiles, envVarNames.CnabCredentialFiles, credentialFilesDirectory))
+
+	// The bundle reference is either a tag or, if the template is pinned to the bundle digest, a digested reference
+	script.SetLiteralVariable("BUNDLE_REFERENCE", bundleReference)
+	script.SetLiteralVariable("PORTER_DEBUG", porterDebug)
+	script.AddSteps(`porter bundle ${PORTER_COMMAND} "${2}" ${PARAMS_FILE:+-p "${PARAMS_FILE}"} ${CREDS_FILE:+--cred "${CREDS_FILE}"} --reference "${BUNDLE_REFERENCE}" -d azure ${PORTER_DEBUG}`)
+
+	script.AddComment("Tracing is disabled so that output values are not written to the deployment script log")
+	script.AddSteps("set +x")
+	script.SetLiteralVariable("SECRET_IDS", string(secretIds))
+
+	// Sensitive outputs are only written to the key vault, the secret id is returned in place of the value.
+	// An output may not exist if the action does not produce it, in which case the secret id is empty
+	if len(keyVaultOutputs) > 0 {
+		script.AddForEach("OUTPUT_NAME", strings.Join(keyVaultOutputs, " "),
+			"SECRET_FILE=$(mktemp)",
+			`porter inst outputs show "${OUTPUT_NAME}" -i "${2}" > "${SECRET_FILE}" || { rm -f "${SECRET_FILE}"; continue; }`,
+			`SECRET_ID=$(az keyvault secret set --vault-name "${CNAB_KEYVAULT_NAME}" --name "${OUTPUT_NAME//_/-}" --file "${SECRET_FILE}" --encoding utf-8 --query id -o tsv)`,
+			`rm -f "${SECRET_FILE}"`,
+			`SECRET_IDS=$(echo "${SECRET_IDS}" | jq --arg name "${OUTPUT_NAME}" --arg id "${SECRET_ID}" '. + {($name): $id}')`)
+	}
+
+	script.SetLiteralVariable("OUTPUT_TYPES", string(outputTypes))
+	script.SetVariable("OUTPUTS", `$(porter inst outputs list -i "${2}" -o json || true)`)
+	script.AddIf(`[[ -z "${OUTPUTS}" ]]`, "OUTPUTS='[]'")
+	// Outputs that the action did not produce are set to an empty value of the output type so that the template outputs can always be evaluated
+	script.AddSteps(`echo "${OUTPUTS}" | jq --argjson types "${OUTPUT_TYPES}" --argjson secrets "${SECRET_IDS}" '($types | map_values(if . == "string" then "" elif . == "int" then 0 elif . == "bool" then false elif . == "array" then [] else {} end)) + (map(select($types[.Name] != null)) | map({key: .Name, value: (if $types[.Name] == "string" then .Value else (.Value | try fromjson catch .) end)}) | from_entries) + $secrets' > "${AZ_SCRIPTS_OUTPUT_PATH}"`)
+
+	return script.String(), nil
 }
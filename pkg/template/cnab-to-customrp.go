@@ -8,12 +8,13 @@ import (
 )
 
 const CustomRPContainerGroupName = "cnab-custom-resource"
+const CustomRPContainerName = "custom-resource-container"
 const CustomRPName = "public"
 const CustomRPAPIVersion = "2018-09-01-preview"
 const CustomRPTypeName = "installs"
 
 // NewCnabCustomRPTemplate creates a new instance of Template for running a CNAB bundle using cnab-azure-driver
-func NewCnabCustomRPTemplate(bundleName string, bundleImage string, customTypeInfo *Type) (*Template, error) {
+func NewCnabCustomRPTemplate(bundleName string, bundleImage string, customTypeInfo *Type, cloud *common.CloudEnvironment, locations []string) (*Template, error) {
 	typeName := CustomRPTypeName
 	if customTypeInfo != nil {
 		typeName = customTypeInfo.Type
@@ -160,7 +161,7 @@ func NewCnabCustomRPTemplate(bundleName string, bundleImage string, customTypeIn
 						},
 					},
 					{
-						Name: "custom-resource-container",
+						Name: CustomRPContainerName,
 						Properties: &ContainerProperties{
 							Image: "cnabquickstarts.azurecr.io/cnabcustomrphandler:latest",
 							Ports: []ContainerPorts{
@@ -190,6 +191,10 @@ func NewCnabCustomRPTemplate(bundleName string, bundleImage string, customTypeIn
 									Name:  "CNAB_AZURE_STATE_FILESHARE",
 									Value: "[variables('cnab_azure_state_fileshare')]",
 								},
+								{
+									Name:        "AZURE_STORAGE_CONNECTION_STRING",
+									SecureValue: fmt.Sprintf("[format('AccountName={0};AccountKey={1};EndpointSuffix=%s', variables('cnab_azure_state_storage_account_name'), listKeys(resourceId('Microsoft.Storage/storageAccounts', variables('cnab_azure_state_storage_account_name')), '2019-04-01').keys[0].value)]", cloud.StorageEndpointSuffix),
+								},
 								{
 									Name:  "CNAB_AZURE_SUBSCRIPTION_ID",
 									Value: "[subscription().subscriptionId]",
@@ -265,7 +270,7 @@ func NewCnabCustomRPTemplate(bundleName string, bundleImage string, customTypeIn
 								{
 									debug
 								}
-								',variables('endPointDNSPrefix'),'.',parameters('location'),'.azurecontainer.io {
+								',variables('endPointDNSName'),' {
 									log {
 											output stdout
 											format console
@@ -326,38 +331,7 @@ func NewCnabCustomRPTemplate(bundleName string, bundleImage string, customTypeIn
 	}
 
 	parameters := map[string]Parameter{
-		// TODO:The allowed values should be generated automatically based on ACI availability
-		"location": {
-			Type: "string",
-			AllowedValues: []string{
-				"australiaeast",
-				"brazilsouth",
-				"canadacentral",
-				"centralindia",
-				"centralus",
-				"chinaeast2",
-				"eastasia",
-				"eastus",
-				"eastus2",
-				"francecentral",
-				"japaneast",
-				"koreacentral",
-				"northcentralus",
-				"northeurope",
-				"southcentralus",
-				"southeastasia",
-				"southindia",
-				"uksouth",
-				"westeurope",
-				"westcentralus",
-				"westus",
-				"westus2",
-			},
-			Metadata: &Metadata{
-				Description: "The location in which the resources will be created.",
-			},
-			DefaultValue: common.ParameterDefaults["location"],
-		},
+		"location": newLocationParameter(locations),
 
 		"debug": {
 			Type: "bool",
@@ -376,7 +350,7 @@ func NewCnabCustomRPTemplate(bundleName string, bundleImage string, customTypeIn
 		"msi_name":                              "cnabcustomrp",
 		"roleAssignmentId":                      "[guid(concat(resourceGroup().id,variables('msi_name'), 'contributor'))]",
 		"endPointDNSPrefix":                     "[replace(variables('cnab_azure_state_fileshare'),'-','')]",
-		"endPointDNSName":                       "[concat(variables('endPointDNSPrefix'),'.',tolower(replace(parameters('location'),' ','')),'.azurecontainer.io')]",
+		"endPointDNSName":                       fmt.Sprintf("[concat(variables('endPointDNSPrefix'),'.',tolower(replace(parameters('location'),' ','')),'.%s')]", cloud.ContainerInstanceDNSSuffix),
 		"stateTableName":                        "installstate",
 		"aysncOpTableName":                      "asyncops",
 	}
@@ -0,0 +1,42 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/common"
+	"gotest.tools/assert"
+)
+
+func TestCustomRPTemplateUsesCloudEnvironment(t *testing.T) {
+	for _, name := range common.GetCloudEnvironmentNames() {
+		cloud, err := common.GetCloudEnvironment(name)
+		assert.NilError(t, err)
+
+		customRPTemplate, err := NewCnabCustomRPTemplate("test", "example.com/test:v1", nil, cloud, cloud.Locations)
+		assert.NilError(t, err)
+
+		endPointDNSName := customRPTemplate.Variables["endPointDNSName"].(string)
+		assert.Assert(t, strings.HasSuffix(endPointDNSName, fmt.Sprintf(".%s')]", cloud.ContainerInstanceDNSSuffix)), "cloud %s endpoint %s", name, endPointDNSName)
+
+		var connectionString string
+		for _, resource := range customRPTemplate.Resources {
+			properties, ok := resource.Properties.(ContainerGroupsProperties)
+			if !ok {
+				continue
+			}
+			for _, container := range properties.Containers {
+				if container.Name != CustomRPContainerName {
+					continue
+				}
+				for _, env := range container.Properties.EnvironmentVariables {
+					if env.Name == "AZURE_STORAGE_CONNECTION_STRING" {
+						connectionString = env.SecureValue
+					}
+				}
+			}
+		}
+		assert.Assert(t, strings.Contains(connectionString, fmt.Sprintf("EndpointSuffix=%s'", cloud.StorageEndpointSuffix)), "cloud %s connection string %s", name, connectionString)
+	}
+}
@@ -0,0 +1,192 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// armExpression is a node in a parsed ARM template expression
+type armExpression interface{}
+
+type armCall struct {
+	name string
+	args []armExpression
+}
+
+type armString struct {
+	value string
+}
+
+type armNumber struct {
+	value string
+}
+
+type armProperty struct {
+	target armExpression
+	name   string
+}
+
+type armIndex struct {
+	target armExpression
+	index  armExpression
+}
+
+type armExpressionParser struct {
+	expression string
+	pos        int
+}
+
+// parseARMExpression parses an ARM template expression, the expression should not include the enclosing square brackets
+func parseARMExpression(expression string) (armExpression, error) {
+	parser := armExpressionParser{
+		expression: expression,
+	}
+
+	result, err := parser.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	parser.skipWhitespace()
+	if parser.pos < len(parser.expression) {
+		return nil, fmt.Errorf("Unexpected character '%c' at position %d in expression %s", parser.expression[parser.pos], parser.pos, expression)
+	}
+
+	return result, nil
+}
+
+func (p *armExpressionParser) skipWhitespace() {
+	for p.pos < len(p.expression) && unicode.IsSpace(rune(p.expression[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *armExpressionParser) peek() byte {
+	p.skipWhitespace()
+	if p.pos < len(p.expression) {
+		return p.expression[p.pos]
+	}
+	return 0
+}
+
+func (p *armExpressionParser) expect(c byte) error {
+	if p.peek() != c {
+		return fmt.Errorf("Expected '%c' at position %d in expression %s", c, p.pos, p.expression)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *armExpressionParser) parseExpression() (armExpression, error) {
+	expression, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch p.peek() {
+		case '.':
+			p.pos++
+			name := p.parseIdentifier()
+			if len(name) == 0 {
+				return nil, fmt.Errorf("Expected property name at position %d in expression %s", p.pos, p.expression)
+			}
+			expression = &armProperty{target: expression, name: name}
+		case '[':
+			p.pos++
+			index, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(']'); err != nil {
+				return nil, err
+			}
+			expression = &armIndex{target: expression, index: index}
+		default:
+			return expression, nil
+		}
+	}
+}
+
+func (p *armExpressionParser) parsePrimary() (armExpression, error) {
+	c := p.peek()
+	switch {
+	case c == '\'':
+		return p.parseString()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseNumber(), nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		name := p.parseIdentifier()
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		call := armCall{name: name}
+		if p.peek() == ')' {
+			p.pos++
+			return &call, nil
+		}
+		for {
+			arg, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if p.peek() == ',' {
+				p.pos++
+				continue
+			}
+			if err := p.expect(')'); err != nil {
+				return nil, err
+			}
+			return &call, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unexpected character at position %d in expression %s", p.pos, p.expression)
+}
+
+func (p *armExpressionParser) parseIdentifier() string {
+	p.skipWhitespace()
+	start := p.pos
+	for p.pos < len(p.expression) {
+		c := rune(p.expression[p.pos])
+		if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			break
+		}
+		p.pos++
+	}
+	return p.expression[start:p.pos]
+}
+
+func (p *armExpressionParser) parseNumber() armExpression {
+	start := p.pos
+	if p.expression[p.pos] == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.expression) && p.expression[p.pos] >= '0' && p.expression[p.pos] <= '9' {
+		p.pos++
+	}
+	return &armNumber{value: p.expression[start:p.pos]}
+}
+
+// parseString parses a single quoted string literal, a single quote is escaped by doubling it
+func (p *armExpressionParser) parseString() (armExpression, error) {
+	p.pos++
+	builder := strings.Builder{}
+	for p.pos < len(p.expression) {
+		c := p.expression[p.pos]
+		p.pos++
+		if c == '\'' {
+			if p.pos < len(p.expression) && p.expression[p.pos] == '\'' {
+				builder.WriteByte('\'')
+				p.pos++
+				continue
+			}
+			return &armString{value: builder.String()}, nil
+		}
+		builder.WriteByte(c)
+	}
+
+	return nil, fmt.Errorf("Unterminated string in expression %s", p.expression)
+}
@@ -0,0 +1,101 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+)
+
+// scriptIndent is the indentation used for the body of functions and blocks in the script
+const scriptIndent = "  "
+
+// Script is a bash script that is run by the deployment script resource
+type Script struct {
+	lines []string
+}
+
+// NewScript creates a new Script that exits on the first error and traces each command
+func NewScript() *Script {
+	script := Script{}
+	script.AddSteps("set -euxo pipefail")
+	return &script
+}
+
+// AddComment adds a comment to the script
+func (script *Script) AddComment(comment string) {
+	script.lines = append(script.lines, fmt.Sprintf("# %s", comment))
+}
+
+// AddSteps adds commands to the script, each step is written on a separate line
+func (script *Script) AddSteps(steps ...string) {
+	script.lines = append(script.lines, steps...)
+}
+
+// SetVariable sets a shell variable, the value is expanded by the shell
+func (script *Script) SetVariable(name string, value string) {
+	script.lines = append(script.lines, fmt.Sprintf("%s=\"%s\"", name, value))
+}
+
+// SetLiteralVariable sets a shell variable to a literal value, the value is quoted so that it is not expanded by the shell
+func (script *Script) SetLiteralVariable(name string, value string) {
+	script.lines = append(script.lines, fmt.Sprintf("%s=%s", name, shellQuote(value)))
+}
+
+// ExportVariable sets and exports a shell variable, the value is expanded by the shell
+func (script *Script) ExportVariable(name string, value string) {
+	script.lines = append(script.lines, fmt.Sprintf("export %s=\"%s\"", name, value))
+}
+
+// AddFunction adds a shell function to the script
+func (script *Script) AddFunction(name string, body ...string) {
+	script.lines = append(script.lines, fmt.Sprintf("%s() {", name))
+	script.addBlock(body)
+	script.lines = append(script.lines, "}")
+}
+
+// AddIf adds an if statement to the script, the body is only run if the condition is true
+func (script *Script) AddIf(condition string, body ...string) {
+	script.lines = append(script.lines, fmt.Sprintf("if %s; then", condition))
+	script.addBlock(body)
+	script.lines = append(script.lines, "fi")
+}
+
+// AddIfElse adds an if statement with an else branch to the script
+func (script *Script) AddIfElse(condition string, body []string, elseBody []string) {
+	script.lines = append(script.lines, fmt.Sprintf("if %s; then", condition))
+	script.addBlock(body)
+	script.lines = append(script.lines, "else")
+	script.addBlock(elseBody)
+	script.lines = append(script.lines, "fi")
+}
+
+// AddForEach adds a for loop over a list of words to the script
+func (script *Script) AddForEach(variable string, words string, body ...string) {
+	script.lines = append(script.lines, fmt.Sprintf("for %s in %s; do", variable, words))
+	script.addBlock(body)
+	script.lines = append(script.lines, "done")
+}
+
+// AddEnvironmentVariableFile adds steps that write the value of an environment variable to a new temporary file, the value is not traced.
+// The name of the file is stored in the variable fileVariable, the variable is empty if the environment variable is not set
+func (script *Script) AddEnvironmentVariableFile(fileVariable string, envVarName string) {
+	script.SetVariable(fileVariable, "")
+	script.AddIf(fmt.Sprintf(`[[ -n "${%s:-}" ]]`, envVarName),
+		fmt.Sprintf(`%s="$(mktemp)"`, fileVariable),
+		fmt.Sprintf(`printenv %s > "${%s}"`, envVarName, fileVariable))
+}
+
+// String renders the script
+func (script *Script) String() string {
+	return strings.Join(script.lines, "\n") + "\n"
+}
+
+func (script *Script) addBlock(body []string) {
+	for _, line := range body {
+		script.lines = append(script.lines, scriptIndent+line)
+	}
+}
+
+// shellQuote quotes a value in single quotes so that the shell does not expand it
+func shellQuote(value string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(value, "'", `'\''`))
+}
@@ -0,0 +1,109 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestCreateScript(t *testing.T) {
+	outputs := map[string]string{
+		"connection_string": "string",
+		"port":              "int",
+	}
+	keyVaultOutputs := []string{"admin_password"}
+
+	tests := []struct {
+		name               string
+		mirror             bool
+		debug              bool
+		expectedOutputPath string
+	}{
+		{"default", false, false, "testdata/script.sh"},
+		{"debug", false, true, "testdata/script-debug.sh"},
+		{"mirror", true, false, "testdata/script-mirror.sh"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			generated, err := createScript("bundle", "example.azurecr.io/bundle:v1", outputs, keyVaultOutputs, test.mirror, test.debug)
+			assert.NilError(t, err)
+
+			expectedBytes, err := ioutil.ReadFile(test.expectedOutputPath)
+			if err != nil {
+				t.Fatalf("failed reading expected output: %s", err)
+			}
+
+			assert.Equal(t, string(expectedBytes), generated)
+		})
+	}
+}
+
+func TestInstallationChecks(t *testing.T) {
+	script := NewScript()
+	addInstallationChecks(script, "bundle")
+
+	expectedBytes, err := ioutil.ReadFile("testdata/installation-checks.sh")
+	if err != nil {
+		t.Fatalf("failed reading expected output: %s", err)
+	}
+	assert.Equal(t, string(expectedBytes), script.String())
+
+	for _, command := range []string{"bash", "jq"} {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("%s is required to run the installation checks", command)
+		}
+	}
+
+	tests := []struct {
+		name              string
+		installation      string
+		action            string
+		allowBundleChange string
+		expectedError     string
+	}{
+		{"new installation", "", "install", "false", ""},
+		{"same bundle", `{"installation":"test","bundle":{"name":"bundle"}}`, "install", "false", ""},
+		{"different bundle", `{"installation":"test","bundle":{"name":"other"}}`, "upgrade", "false", "Installation test is an installation of bundle other not bundle, set the allow_bundle_change parameter to true to perform the upgrade action using bundle bundle"},
+		{"different bundle with allow_bundle_change", `{"installation":"test","bundle":{"name":"other"}}`, "upgrade", "True", ""},
+		{"unknown bundle", `{"installation":"test","bundle":"bundle"}`, "uninstall", "false", "Unable to determine the bundle of installation test, set the allow_bundle_change parameter to true to perform the uninstall action using bundle bundle"},
+		{"unknown bundle with allow_bundle_change", `{"installation":"test"}`, "uninstall", "true", ""},
+		{"missing installation", "", "uninstall", "false", "Installation test does not exist, the uninstall action can only be performed on an existing installation"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "installation-checks")
+			assert.NilError(t, err)
+			defer os.RemoveAll(dir)
+
+			// porter show outputs the installation if it exists, otherwise it fails
+			porter := "#!/bin/bash\nexit 1\n"
+			if len(test.installation) > 0 {
+				porter = fmt.Sprintf("#!/bin/bash\necho %s\n", shellQuote(test.installation))
+			}
+			assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "porter"), []byte(porter), 0700))
+			scriptPath := filepath.Join(dir, "script.sh")
+			assert.NilError(t, ioutil.WriteFile(scriptPath, expectedBytes, 0600))
+
+			cmd := exec.Command("bash", scriptPath, "v0.28.1", "test", test.action)
+			cmd.Env = append(os.Environ(), "PATH="+dir+string(os.PathListSeparator)+os.Getenv("PATH"), "CNAB_ALLOW_BUNDLE_CHANGE="+test.allowBundleChange)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			err = cmd.Run()
+			if len(test.expectedError) == 0 {
+				assert.NilError(t, err, stderr.String())
+				return
+			}
+			assert.Assert(t, err != nil, "the script should fail")
+			assert.Assert(t, strings.Contains(stderr.String(), test.expectedError), stderr.String())
+		})
+	}
+}
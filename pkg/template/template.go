@@ -9,6 +9,8 @@ import (
 const (
 	//DeploymentScriptName is the value of the ContainerGroup Resource Name property in the generated template
 	DeploymentScriptName = "[variables('deploymentScriptResourceName')]"
+	//BundleTagMetadataName is the name of the template metadata value that records the tag of the bundle when the template references the bundle by digest
+	BundleTagMetadataName = "bundleTag"
 )
 
 // Type defines type definition in custom metadata in a bundle
@@ -32,6 +34,7 @@ type ChildType struct {
 type Template struct {
 	Schema         string                 `json:"$schema"`
 	ContentVersion string                 `json:"contentVersion"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty"`
 	Parameters     map[string]Parameter   `json:"parameters"`
 	Variables      map[string]interface{} `json:"variables"`
 	Resources      []Resource             `json:"resources"`
@@ -81,6 +84,31 @@ type StorageProperties struct {
 	Encryption Encryption `json:"encryption"`
 }
 
+// KeyVaultProperties defines the properties of the key vault in the generated template
+type KeyVaultProperties struct {
+	TenantId       string                 `json:"tenantId"`
+	Sku            KeyVaultSku            `json:"sku"`
+	AccessPolicies []KeyVaultAccessPolicy `json:"accessPolicies"`
+}
+
+// KeyVaultSku defines the SKU of the key vault in the generated template
+type KeyVaultSku struct {
+	Family string `json:"family"`
+	Name   string `json:"name"`
+}
+
+// KeyVaultAccessPolicy defines an access policy for the key vault in the generated template
+type KeyVaultAccessPolicy struct {
+	TenantId    string              `json:"tenantId"`
+	ObjectId    string              `json:"objectId"`
+	Permissions KeyVaultPermissions `json:"permissions"`
+}
+
+// KeyVaultPermissions defines the permissions granted by a key vault access policy
+type KeyVaultPermissions struct {
+	Secrets []string `json:"secrets"`
+}
+
 // DeploymentScript properties defines the properties of the deployment script in the generated template
 // TODO fix Retention Interval and Timeout types
 type DeploymentScriptProperties struct {
@@ -286,9 +314,10 @@ type RoleAssignment struct {
 
 // CNABInstallation defines a properties for an ARC installations resource
 type CNABInstallation struct {
-	Reference  string            `json:"reference"`
-	Action     string            `json:"action"`
-	Parameters map[string]string `json:"parameters,omitempty"`
+	Reference   string            `json:"reference"`
+	Action      string            `json:"action"`
+	Parameters  interface{}       `json:"parameters,omitempty"`
+	Credentials map[string]string `json:"credentials,omitempty"`
 }
 
 // Output defines an output in the generated template
@@ -316,6 +345,14 @@ func (template *Template) SetDeploymentScriptEnvironmentVariable(environmentVari
 	return nil
 }
 
+// SetMetadata sets a value in the template metadata
+func (template *Template) SetMetadata(name string, value interface{}) {
+	if template.Metadata == nil {
+		template.Metadata = make(map[string]interface{})
+	}
+	template.Metadata[name] = value
+}
+
 // SetCustomRPAction sets an action for a CustomRP
 func (template *Template) SetCustomRPAction(customRPAction CustomProviderAction) error {
 	customRP, err := template.FindResource(CustomRPName)
@@ -0,0 +1,24 @@
+set -euxo pipefail
+BUNDLE_NAME='bundle'
+ACTION="${3}"
+INSTALLED='false'
+INSTALLED_BUNDLE=""
+if INSTALLATION="$(porter show "${2}" -o json 2>/dev/null)" && [[ -n "${INSTALLATION}" ]]; then
+  INSTALLED='true'
+  INSTALLED_BUNDLE="$(echo "${INSTALLATION}" | jq -r '.bundle | objects | .name // empty')"
+fi
+if [[ "${ACTION}" == 'install' && "${INSTALLED}" == 'true' ]]; then
+  ACTION='upgrade'
+fi
+if [[ "${ACTION}" == 'upgrade' && "${INSTALLED}" == 'false' ]]; then
+  ACTION='install'
+fi
+if [[ "${ACTION}" != 'install' && "${INSTALLED}" == 'false' ]]; then
+  echo "Installation ${2} does not exist, the ${ACTION} action can only be performed on an existing installation" >&2
+  exit 1
+fi
+if [[ "${INSTALLED}" == 'true' && "${INSTALLED_BUNDLE}" != "${BUNDLE_NAME}" && "${CNAB_ALLOW_BUNDLE_CHANGE,,}" != 'true' ]]; then
+  if [[ -z "${INSTALLED_BUNDLE}" ]]; then echo "Unable to determine the bundle of installation ${2}, set the allow_bundle_change parameter to true to perform the ${ACTION} action using bundle ${BUNDLE_NAME}" >&2; exit 1; fi
+  echo "Installation ${2} is an installation of bundle ${INSTALLED_BUNDLE} not ${BUNDLE_NAME}, set the allow_bundle_change parameter to true to perform the ${ACTION} action using bundle ${BUNDLE_NAME}" >&2
+  exit 1
+fi
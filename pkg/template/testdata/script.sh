@@ -0,0 +1,86 @@
+set -euxo pipefail
+# verify_checksum verifies the SHA-256 checksum of the file in the first argument if a checksum is passed in the second argument
+verify_checksum() {
+  if [[ -n "${2:-}" ]]; then echo "${2}  ${1}" | sha256sum -c -; fi
+}
+PORTER_HOME="${HOME}/.porter"
+PORTER_VERSION="${1}"
+mkdir -p "${PORTER_HOME}/plugins/azure" "${HOME}/.cnab-azure-driver"
+PORTER_URL='https://cdn.porter.sh'
+curl -fsSLo "${PORTER_HOME}/porter" "${PORTER_URL}/${PORTER_VERSION}/porter-linux-amd64"
+verify_checksum "${PORTER_HOME}/porter" "${PORTER_CHECKSUM:-}"
+curl -fsSLo "${PORTER_HOME}/plugins/azure/azure" "${PORTER_URL}/plugins/azure/${AZURE_PLUGIN_VERSION}/azure-linux-amd64"
+verify_checksum "${PORTER_HOME}/plugins/azure/azure" "${AZURE_PLUGIN_CHECKSUM:-}"
+chmod +x "${PORTER_HOME}/porter" "${PORTER_HOME}/plugins/azure/azure"
+if [[ "${CNAB_AZURE_DRIVER_VERSION}" == 'latest' ]]; then
+  DOWNLOAD_LOCATION=$(curl -fsSL https://api.github.com/repos/deislabs/cnab-azure-driver/releases/latest | jq -r '.assets[] | select(.name == "cnab-azure-linux-amd64").browser_download_url')
+else
+  DOWNLOAD_LOCATION="https://github.com/deislabs/cnab-azure-driver/releases/download/${CNAB_AZURE_DRIVER_VERSION}/cnab-azure-linux-amd64"
+fi
+curl -fsSLo "${HOME}/.cnab-azure-driver/cnab-azure" "${DOWNLOAD_LOCATION}"
+verify_checksum "${HOME}/.cnab-azure-driver/cnab-azure" "${CNAB_AZURE_DRIVER_CHECKSUM:-}"
+chmod +x "${HOME}/.cnab-azure-driver/cnab-azure"
+export PATH="${PORTER_HOME}:${HOME}/.cnab-azure-driver:${PATH}"
+echo 'default-storage-plugin = "azure.table"' > "${PORTER_HOME}/config.toml"
+cat "${PORTER_HOME}/config.toml"
+BUNDLE_NAME='bundle'
+ACTION="${3}"
+INSTALLED='false'
+INSTALLED_BUNDLE=""
+if INSTALLATION="$(porter show "${2}" -o json 2>/dev/null)" && [[ -n "${INSTALLATION}" ]]; then
+  INSTALLED='true'
+  INSTALLED_BUNDLE="$(echo "${INSTALLATION}" | jq -r '.bundle | objects | .name // empty')"
+fi
+if [[ "${ACTION}" == 'install' && "${INSTALLED}" == 'true' ]]; then
+  ACTION='upgrade'
+fi
+if [[ "${ACTION}" == 'upgrade' && "${INSTALLED}" == 'false' ]]; then
+  ACTION='install'
+fi
+if [[ "${ACTION}" != 'install' && "${INSTALLED}" == 'false' ]]; then
+  echo "Installation ${2} does not exist, the ${ACTION} action can only be performed on an existing installation" >&2
+  exit 1
+fi
+if [[ "${INSTALLED}" == 'true' && "${INSTALLED_BUNDLE}" != "${BUNDLE_NAME}" && "${CNAB_ALLOW_BUNDLE_CHANGE,,}" != 'true' ]]; then
+  if [[ -z "${INSTALLED_BUNDLE}" ]]; then echo "Unable to determine the bundle of installation ${2}, set the allow_bundle_change parameter to true to perform the ${ACTION} action using bundle ${BUNDLE_NAME}" >&2; exit 1; fi
+  echo "Installation ${2} is an installation of bundle ${INSTALLED_BUNDLE} not ${BUNDLE_NAME}, set the allow_bundle_change parameter to true to perform the ${ACTION} action using bundle ${BUNDLE_NAME}" >&2
+  exit 1
+fi
+export CNAB_ACTION="${ACTION}"
+PORTER_COMMAND="${ACTION}"
+if [[ ' install upgrade uninstall ' != *" ${ACTION} "* ]]; then
+  PORTER_COMMAND="invoke --action ${ACTION}"
+fi
+PARAMS_FILE=""
+if [[ -n "${CNAB_PARAMETER_SET:-}" ]]; then
+  PARAMS_FILE="$(mktemp)"
+  printenv CNAB_PARAMETER_SET > "${PARAMS_FILE}"
+fi
+CREDS_FILE=""
+if [[ -n "${CNAB_CREDENTIAL_SET:-}" ]]; then
+  CREDS_FILE="$(mktemp)"
+  printenv CNAB_CREDENTIAL_SET > "${CREDS_FILE}"
+fi
+if [[ -n "${CNAB_CREDENTIAL_FILES:-}" ]]; then
+  mkdir -p '/tmp/cnab-credentials'
+  printenv CNAB_CREDENTIAL_FILES | jq -r 'keys[]' | while IFS= read -r NAME; do printenv CNAB_CREDENTIAL_FILES | jq -r --arg name "${NAME}" '.[$name]' | base64 -d > "/tmp/cnab-credentials/${NAME}"; done
+fi
+BUNDLE_REFERENCE='example.azurecr.io/bundle:v1'
+PORTER_DEBUG=''
+porter bundle ${PORTER_COMMAND} "${2}" ${PARAMS_FILE:+-p "${PARAMS_FILE}"} ${CREDS_FILE:+--cred "${CREDS_FILE}"} --reference "${BUNDLE_REFERENCE}" -d azure ${PORTER_DEBUG}
+# Tracing is disabled so that output values are not written to the deployment script log
+set +x
+SECRET_IDS='{"admin_password":""}'
+for OUTPUT_NAME in admin_password; do
+  SECRET_FILE=$(mktemp)
+  porter inst outputs show "${OUTPUT_NAME}" -i "${2}" > "${SECRET_FILE}" || { rm -f "${SECRET_FILE}"; continue; }
+  SECRET_ID=$(az keyvault secret set --vault-name "${CNAB_KEYVAULT_NAME}" --name "${OUTPUT_NAME//_/-}" --file "${SECRET_FILE}" --encoding utf-8 --query id -o tsv)
+  rm -f "${SECRET_FILE}"
+  SECRET_IDS=$(echo "${SECRET_IDS}" | jq --arg name "${OUTPUT_NAME}" --arg id "${SECRET_ID}" '. + {($name): $id}')
+done
+OUTPUT_TYPES='{"connection_string":"string","port":"int"}'
+OUTPUTS="$(porter inst outputs list -i "${2}" -o json || true)"
+if [[ -z "${OUTPUTS}" ]]; then
+  OUTPUTS='[]'
+fi
+echo "${OUTPUTS}" | jq --argjson types "${OUTPUT_TYPES}" --argjson secrets "${SECRET_IDS}" '($types | map_values(if . == "string" then "" elif . == "int" then 0 elif . == "bool" then false elif . == "array" then [] else {} end)) + (map(select($types[.Name] != null)) | map({key: .Name, value: (if $types[.Name] == "string" then .Value else (.Value | try fromjson catch .) end)}) | from_entries) + $secrets' > "${AZ_SCRIPTS_OUTPUT_PATH}"
@@ -11,10 +11,10 @@ import (
 	"github.com/simongdavies/CNAB.ARM-Converter/pkg/template"
 )
 
-func NewCreateUIDefinition(bundleName string, bundleDescription string, generatedTemplate *template.Template, simplyfy bool, useAKS bool, custom map[string]interface{}, customRPUI bool, includeResource bool, isARCResource bool, isDogfood bool) (*CreateUIDefinition, error) {
+func NewCreateUIDefinition(bundleName string, bundleDescription string, generatedTemplate *template.Template, simplyfy bool, useAKS bool, custom map[string]interface{}, customRPUI bool, includeResource bool, isARCResource bool, profile *common.CloudProfile) (*CreateUIDefinition, error) {
 
 	if isARCResource {
-		return NewArcCreateUIDefinition(bundleName, bundleDescription, generatedTemplate, simplyfy, custom, customRPUI, includeResource, isDogfood)
+		return NewArcCreateUIDefinition(bundleName, bundleDescription, generatedTemplate, simplyfy, custom, customRPUI, includeResource, profile)
 	}
 
 	locationLabel := "CNAB Action Location"
@@ -90,6 +90,13 @@ func NewCreateUIDefinition(bundleName string, bundleDescription string, generate
 	}
 	outputs := map[string]string{}
 
+	// The locations offered in the UI are the same as those allowed by the location parameter in the template
+	if locationParameter, ok := generatedTemplate.Parameters[common.LocationParameterName]; ok {
+		if locations, ok := locationParameter.AllowedValues.([]string); ok && len(locations) > 0 {
+			UIDef.Parameters.Config.Basics.Location.AllowedValues = locations
+		}
+	}
+
 	elementsMap := map[string][]Element{}
 	elementsMap["basics"] = []Element{}
 	elementsMap["Additional"] = []Element{}
@@ -142,7 +149,7 @@ func NewCreateUIDefinition(bundleName string, bundleDescription string, generate
 		outputs[common.KubeConfigParameterName] = "[first(steps('basics').aksKubeConfig.kubeconfigs).value]"
 	}
 
-	return processParameters(generatedTemplate, custom, &UIDef, outputs, elementsMap, customRPUI)
+	return processParameters(generatedTemplate, custom, &UIDef, outputs, elementsMap, customRPUI, false)
 }
 
 func hasAKSParams(template template.Template) bool {
@@ -157,7 +164,7 @@ func hasARCParams(template template.Template) bool {
 	return customResource && customResourceGroup
 }
 
-func NewArcCreateUIDefinition(bundleName string, bundleDescription string, generatedTemplate *template.Template, simplyfy bool, custom map[string]interface{}, customRPUI bool, includeResource bool, isDogfood bool) (*CreateUIDefinition, error) {
+func NewArcCreateUIDefinition(bundleName string, bundleDescription string, generatedTemplate *template.Template, simplyfy bool, custom map[string]interface{}, customRPUI bool, includeResource bool, profile *common.CloudProfile) (*CreateUIDefinition, error) {
 
 	locationLabel := "CNAB RP Location"
 	locationToolTip := "This is the location where the CNAB RP will be located"
@@ -167,12 +174,6 @@ func NewArcCreateUIDefinition(bundleName string, bundleDescription string, gener
 	}
 
 	//TODO: set permission requests correctly for ARC template
-	provider := "Microsoft.Contoso"
-
-	if isDogfood {
-		provider = "Microsoft.CNAB"
-	}
-
 	UIDef := CreateUIDefinition{
 		Schema:  "https://schema.management.azure.com/schemas/0.1.2-preview/CreateUIDefinition.MultiVm.json#",
 		Handler: "Microsoft.Azure.CreateUIDef",
@@ -184,14 +185,14 @@ func NewArcCreateUIDefinition(bundleName string, bundleDescription string, gener
 					Description: bundleDescription,
 					Subscription: &Subscription{
 						ResourceProviders: []string{
-							provider,
+							profile.ProviderNamespace,
 						},
 					},
 					ResourceGroup: &ResourceGroup{
 						Constraints: ResourceConstraints{
 							Validations: []ResourceValidation{
 								{
-									Permission: fmt.Sprintf("%s/installations/write", provider),
+									Permission: fmt.Sprintf("%s/write", profile.FullResourceType()),
 									Message:    "Permission to create CNAB RP is needed in resource group ",
 								},
 							},
@@ -202,7 +203,7 @@ func NewArcCreateUIDefinition(bundleName string, bundleDescription string, gener
 						Label:   locationLabel,
 						Tooltip: locationToolTip,
 						ResourceTypes: []string{
-							fmt.Sprintf("%s/installations", provider),
+							profile.FullResourceType(),
 						},
 
 						Visible: true,
@@ -219,12 +220,8 @@ func NewArcCreateUIDefinition(bundleName string, bundleDescription string, gener
 	elementsMap["basics"] = []Element{}
 	elementsMap["Additional"] = []Element{}
 
-	// ARC in Dogfood requires location to be West US in prod requires eastus2canary
-
-	locations := []string{"eastus2euap"}
-	if isDogfood {
-		locations = []string{"westus"}
-	}
+	// ARC is only available in the locations defined by the cloud profile
+	locations := profile.Locations
 
 	//TODO: Handle CustomRP and CustomLocation
 
@@ -249,10 +246,12 @@ func NewArcCreateUIDefinition(bundleName string, bundleDescription string, gener
 		outputs[common.CustomLocationResourceParameterName] = "[steps('basics').customLocationSelector.name]"
 	}
 
-	return processParameters(generatedTemplate, custom, &UIDef, outputs, elementsMap, customRPUI)
+	// The credentials of an Arc installation are securestring parameters
+	return processParameters(generatedTemplate, custom, &UIDef, outputs, elementsMap, customRPUI, true)
 }
 
-func processParameters(generatedTemplate *template.Template, custom map[string]interface{}, UIDef *CreateUIDefinition, outputs map[string]string, elementsMap map[string][]Element, customRPUI bool) (*CreateUIDefinition, error) {
+// processParameters adds an element for each template parameter to the UI definition, if secureStringPasswordBoxes is set securestring parameters are rendered as password boxes
+func processParameters(generatedTemplate *template.Template, custom map[string]interface{}, UIDef *CreateUIDefinition, outputs map[string]string, elementsMap map[string][]Element, customRPUI bool, secureStringPasswordBoxes bool) (*CreateUIDefinition, error) {
 
 	var settings CustomSettings
 	if customSettings := custom["com.azure.creatuidef"]; customSettings != nil {
@@ -341,6 +340,17 @@ func processParameters(generatedTemplate *template.Template, custom map[string]i
 		case strings.Contains(strings.ToLower(name), "password"):
 			elementsMap["basics"] = append(elementsMap["basics"], createPasswordBox(name, trimLabel(val.Metadata.Description), val.Metadata.Description, val.DefaultValue, "", ""))
 
+		case secureStringPasswordBoxes && val.Type == "securestring":
+			label := trimLabel(val.Metadata.Description)
+			if label == "" {
+				label = strings.ToTitle(name)
+			}
+			element := createPasswordBox(name, label, val.Metadata.Description, val.DefaultValue, "", "")
+			if !isRequired(val.DefaultValue) {
+				step = "Additional"
+			}
+			elementsMap[step] = append(elementsMap[step], element)
+
 		case val.Type == "bool":
 			defaultValue, ok := val.DefaultValue.(bool)
 			if !ok {
@@ -0,0 +1,72 @@
+package uidefinition
+
+import (
+	"testing"
+
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/common"
+	"github.com/simongdavies/CNAB.ARM-Converter/pkg/template"
+	"gotest.tools/assert"
+)
+
+// findElement returns the element with a name from the basics and the steps of a UI definition
+func findElement(t *testing.T, UIDef *CreateUIDefinition, name string) Element {
+	elements := UIDef.Parameters.Basics
+	for _, step := range UIDef.Parameters.Steps {
+		elements = append(elements, step.Elements...)
+	}
+	for _, element := range elements {
+		if element.Name == name {
+			return element
+		}
+	}
+	t.Fatalf("UI definition does not contain element %s", name)
+	return Element{}
+}
+
+func TestSecureStringParameterElements(t *testing.T) {
+	profile, err := common.GetCloudProfile(common.DefaultCloudProfileName)
+	assert.NilError(t, err)
+
+	generatedTemplate := template.Template{
+		Parameters: map[string]template.Parameter{
+			"token":     {Type: "securestring", Metadata: &template.Metadata{Description: "The API token"}},
+			"kubeproxy": {Type: "securestring", Metadata: &template.Metadata{}},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		isArc        bool
+		expectedType string
+	}{
+		{"deployment script template", false, "Microsoft.Common.TextBox"},
+		{"arc template", true, "Microsoft.Common.PasswordBox"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			UIDef, err := NewCreateUIDefinition("test", "test bundle", &generatedTemplate, false, false, nil, false, false, test.isArc, profile)
+			assert.NilError(t, err)
+
+			for _, name := range []string{"token", "kubeproxy"} {
+				assert.Equal(t, test.expectedType, findElement(t, UIDef, name).Type, name)
+			}
+		})
+	}
+}
+
+func TestArcSecureStringParameterLabel(t *testing.T) {
+	profile, err := common.GetCloudProfile(common.DefaultCloudProfileName)
+	assert.NilError(t, err)
+
+	generatedTemplate := template.Template{
+		Parameters: map[string]template.Parameter{
+			"kubeproxy": {Type: "securestring", Metadata: &template.Metadata{}},
+		},
+	}
+
+	// A credential without a description is labelled with its name
+	UIDef, err := NewArcCreateUIDefinition("test", "test bundle", &generatedTemplate, false, nil, false, false, profile)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, PasswordLabel{Password: "KUBEPROXY", ConfirmPassword: "Confirm KUBEPROXY"}, findElement(t, UIDef, "kubeproxy").Label)
+}